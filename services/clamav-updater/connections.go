@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Connection describes one ClamAV target, modeled on `podman system
+// connection add`: a named URI (unix:// for a local socket, ssh://user@host
+// for a remote Podman machine) plus the container to exec freshclam in.
+type Connection struct {
+	Name      string `json:"name" yaml:"name"`
+	URI       string `json:"uri" yaml:"uri"`
+	Identity  string `json:"identity,omitempty" yaml:"identity,omitempty"`
+	Container string `json:"container,omitempty" yaml:"container,omitempty"`
+	Default   bool   `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// connectionsFile is the top-level shape of the BACKUPLENS_CONNECTIONS file.
+type connectionsFile struct {
+	Connections []Connection `json:"connections" yaml:"connections"`
+}
+
+// loadConnections reads and parses the connections config, choosing a YAML
+// or JSON decoder based on the file extension.
+func loadConnections(path string) ([]Connection, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read connections file: %w", err)
+	}
+
+	var cf connectionsFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &cf)
+	default:
+		err = yaml.Unmarshal(data, &cf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse connections file: %w", err)
+	}
+
+	for i := range cf.Connections {
+		if cf.Connections[i].Container == "" {
+			cf.Connections[i].Container = "clamav"
+		}
+	}
+	return cf.Connections, nil
+}
+
+// targetClient pairs a named Connection with the container-engine client
+// dialed for it once at startup.
+type targetClient struct {
+	conn   Connection
+	client ContainerClient
+}
+
+// connectionPool is the set of configured update targets, keyed by name, as
+// well as the name to use when the caller doesn't specify ?target=.
+type connectionPool struct {
+	mu         sync.RWMutex
+	targets    map[string]*targetClient
+	defaultKey string
+}
+
+var pool = &connectionPool{targets: map[string]*targetClient{}}
+
+// initConnections dials every connection listed in BACKUPLENS_CONNECTIONS up
+// front and caches the resulting clients, so that a fleet of backup hosts
+// can be fanned out to without reconnecting on every request. It is a no-op
+// (leaving the pool empty) when the env var isn't set, in which case the
+// handlers fall back to the single-container behavior: freshclam runs in the
+// container named "clamav" on the engine newContainerClient finds.
+func initConnections() error {
+	path := os.Getenv("BACKUPLENS_CONNECTIONS")
+	if path == "" {
+		return nil
+	}
+
+	conns, err := loadConnections(path)
+	if err != nil {
+		return err
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for _, conn := range conns {
+		ctx := context.Background()
+		client, err := dialConnection(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("connection %q: %w", conn.Name, err)
+		}
+		pool.targets[conn.Name] = &targetClient{conn: conn, client: client}
+		if conn.Default || pool.defaultKey == "" {
+			pool.defaultKey = conn.Name
+		}
+	}
+	return nil
+}
+
+// dialConnection connects to a single target's Podman (or Docker) endpoint.
+// A unix:// or ssh:// URI is dialed through the Podman bindings with the
+// entry's own URI and identity instead of CONTAINER_HOST/CONTAINER_SSHKEY,
+// with no fallback to Docker if that fails. Any other URI gets a Docker
+// Engine client configured from the environment; the URI itself is not used.
+func dialConnection(ctx context.Context, conn Connection) (ContainerClient, error) {
+	if strings.HasPrefix(conn.URI, "unix://") || strings.HasPrefix(conn.URI, "ssh://") {
+		client, err := newPodmanClientAt(ctx, conn.URI, conn.Identity)
+		if err == nil {
+			return client, nil
+		}
+		return nil, err
+	}
+	return newDockerClient(ctx)
+}
+
+func (p *connectionPool) list() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	names := make([]string, 0, len(p.targets))
+	for name := range p.targets {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (p *connectionPool) resolve(target string) ([]*targetClient, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if target == "" {
+		target = p.defaultKey
+	}
+	if target == "all" {
+		out := make([]*targetClient, 0, len(p.targets))
+		for _, t := range p.targets {
+			out = append(out, t)
+		}
+		return out, nil
+	}
+	t, ok := p.targets[target]
+	if !ok {
+		return nil, fmt.Errorf("unknown target %q", target)
+	}
+	return []*targetClient{t}, nil
+}
+
+func (p *connectionPool) configured() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.targets) > 0
+}
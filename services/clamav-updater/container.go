@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/containers/podman/v4/pkg/api/handlers"
+	podmanBindings "github.com/containers/podman/v4/pkg/bindings"
+	"github.com/containers/podman/v4/pkg/bindings/containers"
+	"github.com/docker/docker/api/types"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// ExecResult is the runtime-agnostic outcome of running a command inside a
+// named container, regardless of whether it was executed through Podman's
+// bindings or the Docker Engine SDK.
+type ExecResult struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	// Running is the exec-inspect "still running" flag as reported when the
+	// output stream ended. The handlers in this package classify a result by
+	// ExitCode alone and do not look at it.
+	Running bool
+}
+
+// ExecStream is a running exec whose combined stdout/stderr can be consumed
+// as it is produced, instead of waiting for the command to finish.
+type ExecStream struct {
+	// Output interleaves stdout and stderr lines in arrival order; each line
+	// is already newline-terminated text suitable for bufio.Scanner.
+	Output io.Reader
+	// Wait blocks until the exec has finished and returns its final result.
+	// It must be called exactly once, after Output has been drained to EOF.
+	Wait func() (*ExecResult, error)
+}
+
+// ContainerClient runs a command inside an already-running container and
+// reports its structured result. Implementations must not shell out to the
+// `podman`/`docker` CLI binaries.
+type ContainerClient interface {
+	// Name identifies the backing engine for logging ("podman", "docker").
+	Name() string
+	// ExecRun runs cmd inside container and waits for it to finish.
+	ExecRun(ctx context.Context, container string, cmd []string) (*ExecResult, error)
+	// ExecStream runs cmd inside container and streams its output as it is
+	// produced, for callers that want to forward progress to a client.
+	ExecStream(ctx context.Context, container string, cmd []string) (*ExecStream, error)
+}
+
+// newContainerClient probes for a usable Podman or Docker connection, in
+// that order, and returns the first that succeeds. It never shells out to a
+// CLI binary, so it works even when the updater's own container has neither
+// `podman` nor `docker` installed.
+func newContainerClient(ctx context.Context) (ContainerClient, error) {
+	if c, err := newPodmanClient(ctx); err == nil {
+		return c, nil
+	}
+	if c, err := newDockerClient(ctx); err == nil {
+		return c, nil
+	}
+	return nil, fmt.Errorf("no reachable container engine (tried podman socket and docker engine)")
+}
+
+// podmanClient talks to a Podman REST service via pkg/bindings.
+type podmanClient struct {
+	ctx context.Context
+}
+
+func newPodmanClient(ctx context.Context) (*podmanClient, error) {
+	uri := os.Getenv("CONTAINER_HOST")
+	if uri == "" {
+		uri = "unix:///run/podman/podman.sock"
+	}
+	identity := os.Getenv("CONTAINER_SSHKEY")
+	return newPodmanClientAt(ctx, uri, identity)
+}
+
+// newPodmanClientAt dials a specific Podman REST endpoint, bypassing the
+// CONTAINER_HOST/CONTAINER_SSHKEY environment variables. Used when fanning
+// out to multiple connections configured via BACKUPLENS_CONNECTIONS.
+func newPodmanClientAt(ctx context.Context, uri, identity string) (*podmanClient, error) {
+	connCtx, err := podmanBindings.NewConnectionWithIdentity(ctx, uri, identity, false)
+	if err != nil {
+		return nil, fmt.Errorf("podman: connect %s: %w", uri, err)
+	}
+	return &podmanClient{ctx: connCtx}, nil
+}
+
+func (p *podmanClient) Name() string { return "podman" }
+
+func (p *podmanClient) ExecRun(ctx context.Context, container string, cmd []string) (*ExecResult, error) {
+	execID, err := containers.ExecCreate(p.ctx, container, &handlers.ExecCreateConfig{
+		ExecConfig: types.ExecConfig{
+			Cmd:          cmd,
+			AttachStdout: true,
+			AttachStderr: true,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("podman: exec create: %w", err)
+	}
+
+	var stdout, stderr strings.Builder
+	startOpts := new(containers.ExecStartAndAttachOptions).
+		WithOutputStream(&writerNopCloser{&stdout}).
+		WithErrorStream(&writerNopCloser{&stderr}).
+		WithAttachOutput(true).
+		WithAttachError(true)
+	if err := containers.ExecStartAndAttach(p.ctx, execID, startOpts); err != nil {
+		return nil, fmt.Errorf("podman: exec start: %w", err)
+	}
+
+	inspect, err := containers.ExecInspect(p.ctx, execID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("podman: exec inspect: %w", err)
+	}
+
+	return &ExecResult{
+		ExitCode: inspect.ExitCode,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Running:  inspect.Running,
+	}, nil
+}
+
+func (p *podmanClient) ExecStream(ctx context.Context, container string, cmd []string) (*ExecStream, error) {
+	execID, err := containers.ExecCreate(p.ctx, container, &handlers.ExecCreateConfig{
+		ExecConfig: types.ExecConfig{
+			Cmd:          cmd,
+			AttachStdout: true,
+			AttachStderr: true,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("podman: exec create: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	sw := &syncWriter{w: pw}
+	// stderr is also kept on its own so Wait can report it for classification.
+	var stderr strings.Builder
+	startOpts := new(containers.ExecStartAndAttachOptions).
+		WithOutputStream(sw).
+		WithErrorStream(&syncWriter{w: io.MultiWriter(sw, &stderr)}).
+		WithAttachOutput(true).
+		WithAttachError(true)
+
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- containers.ExecStartAndAttach(p.ctx, execID, startOpts)
+		pw.Close()
+	}()
+
+	wait := func() (*ExecResult, error) {
+		if err := <-startErr; err != nil {
+			return nil, fmt.Errorf("podman: exec start: %w", err)
+		}
+		inspect, err := containers.ExecInspect(p.ctx, execID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("podman: exec inspect: %w", err)
+		}
+		return &ExecResult{ExitCode: inspect.ExitCode, Stderr: stderr.String(), Running: inspect.Running}, nil
+	}
+	return &ExecStream{Output: pr, Wait: wait}, nil
+}
+
+// dockerEngineClient talks to the Docker Engine API via the official SDK.
+type dockerEngineClient struct {
+	cli *dockerClient.Client
+}
+
+func newDockerClient(ctx context.Context) (*dockerEngineClient, error) {
+	cli, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv, dockerClient.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("docker: new client: %w", err)
+	}
+	if _, err := cli.Ping(ctx); err != nil {
+		return nil, fmt.Errorf("docker: ping: %w", err)
+	}
+	return &dockerEngineClient{cli: cli}, nil
+}
+
+func (d *dockerEngineClient) Name() string { return "docker" }
+
+func (d *dockerEngineClient) ExecRun(ctx context.Context, container string, cmd []string) (*ExecResult, error) {
+	created, err := d.cli.ContainerExecCreate(ctx, container, types.ExecConfig{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("docker: exec create: %w", err)
+	}
+
+	attach, err := d.cli.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, fmt.Errorf("docker: exec attach: %w", err)
+	}
+	defer attach.Close()
+
+	var stdout, stderr strings.Builder
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, attach.Reader); err != nil {
+		return nil, fmt.Errorf("docker: demux exec stream: %w", err)
+	}
+
+	inspect, err := d.cli.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return nil, fmt.Errorf("docker: exec inspect: %w", err)
+	}
+
+	return &ExecResult{
+		ExitCode: inspect.ExitCode,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Running:  inspect.Running,
+	}, nil
+}
+
+func (d *dockerEngineClient) ExecStream(ctx context.Context, container string, cmd []string) (*ExecStream, error) {
+	created, err := d.cli.ContainerExecCreate(ctx, container, types.ExecConfig{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("docker: exec create: %w", err)
+	}
+
+	attach, err := d.cli.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, fmt.Errorf("docker: exec attach: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	var stderr strings.Builder
+	demuxErr := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(pw, io.MultiWriter(pw, &stderr), attach.Reader)
+		demuxErr <- err
+		pw.Close()
+		attach.Close()
+	}()
+
+	wait := func() (*ExecResult, error) {
+		if err := <-demuxErr; err != nil && err != io.EOF {
+			return nil, fmt.Errorf("docker: demux exec stream: %w", err)
+		}
+		inspect, err := d.cli.ContainerExecInspect(ctx, created.ID)
+		if err != nil {
+			return nil, fmt.Errorf("docker: exec inspect: %w", err)
+		}
+		return &ExecResult{ExitCode: inspect.ExitCode, Stderr: stderr.String(), Running: inspect.Running}, nil
+	}
+	return &ExecStream{Output: pr, Wait: wait}, nil
+}
+
+// writerNopCloser adapts an io.Writer for APIs that want an io.WriteCloser.
+type writerNopCloser struct {
+	w *strings.Builder
+}
+
+func (w *writerNopCloser) Write(p []byte) (int, error) { return w.w.Write(p) }
+func (w *writerNopCloser) Close() error                { return nil }
+
+// syncWriter serializes concurrent writes from separate stdout/stderr
+// attachments onto a single io.Writer (here, one end of an io.Pipe).
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// Close is a no-op so syncWriter satisfies io.WriteCloser for the attach
+// options; the pipe itself is closed by ExecStream once the exec ends.
+func (s *syncWriter) Close() error { return nil }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const clamdDialTimeout = 2 * time.Second
+
+var maxSignatureAgeHours = 48.0
+
+// SignatureVersions reports the versions parsed out of clamd's zVERSION
+// reply, one per database file it tracks.
+type SignatureVersions struct {
+	ClamAV   string `json:"clamav,omitempty"`
+	Main     string `json:"main_cvd,omitempty"`
+	Daily    string `json:"daily_cvd,omitempty"`
+	Bytecode string `json:"bytecode_cvd,omitempty"`
+}
+
+// HealthResponse is returned by /health. It is kept intentionally close to
+// clamd's own vocabulary (PING/VERSION) so operators can cross-check it
+// against `clamdscan --version` without translation.
+type HealthResponse struct {
+	Status            string            `json:"status"` // healthy | degraded | unhealthy
+	ClamAVHost        string            `json:"clamav_host"`
+	ClamdReachable    bool              `json:"clamd_reachable"`
+	ClamdVersion      string            `json:"clamd_version,omitempty"`
+	SignatureVersions SignatureVersions `json:"signature_versions,omitempty"`
+	SignatureAgeHours float64           `json:"signature_age_hours,omitempty"`
+	Error             string            `json:"error,omitempty"`
+}
+
+// clamdPing opens a short-lived TCP connection to clamd and runs the
+// PING/VERSION handshake described in the clamd protocol documentation.
+func clamdPing(addr string, timeout time.Duration) (version string, err error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return "", fmt.Errorf("dial %s: %w", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return "", fmt.Errorf("send PING: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	pong, err := reader.ReadString(0)
+	if err != nil {
+		return "", fmt.Errorf("read PING reply: %w", err)
+	}
+	if strings.TrimRight(pong, "\x00") != "PONG" {
+		return "", fmt.Errorf("unexpected PING reply: %q", pong)
+	}
+
+	if _, err := conn.Write([]byte("zVERSION\x00")); err != nil {
+		return "", fmt.Errorf("send VERSION: %w", err)
+	}
+	ver, err := reader.ReadString(0)
+	if err != nil {
+		return "", fmt.Errorf("read VERSION reply: %w", err)
+	}
+	return strings.TrimRight(ver, "\x00"), nil
+}
+
+// parseVersionReply parses clamd's zVERSION response, of the form:
+//
+//	ClamAV 1.2.1/27315/Thu Jul 25 08:32:00 2024
+//
+// and, when available, the per-database breakdown some builds append after
+// a pipe, e.g. "|main.cvd:62:...|daily.cvd:27315:Thu Jul 25 08:32:00 2024|bytecode.cvd:...".
+// It returns the ClamAV engine version, the per-database versions, and the
+// age in hours of the newest signature timestamp it could parse.
+func parseVersionReply(reply string) (engineVersion string, versions SignatureVersions, ageHours float64) {
+	parts := strings.Split(reply, "/")
+	if len(parts) > 0 {
+		engineVersion = strings.TrimSpace(parts[0])
+	}
+
+	// The plain form carries the daily database build and its date after the
+	// engine version; a per-database breakdown below overrides the build.
+	var newest time.Time
+	if len(parts) >= 3 {
+		versions.Daily = strings.TrimSpace(parts[1])
+		date := strings.TrimSpace(strings.SplitN(parts[2], "|", 2)[0])
+		if t, err := time.Parse("Mon Jan  2 15:04:05 2006", date); err == nil {
+			newest = t
+		}
+	}
+
+	segments := strings.Split(reply, "|")
+	for _, seg := range segments {
+		fields := strings.SplitN(seg, ":", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		name := strings.TrimSpace(fields[0])
+		build := strings.TrimSpace(fields[1])
+		switch {
+		case strings.EqualFold(name, "main.cvd"), strings.EqualFold(name, "main.cld"):
+			versions.Main = build
+		case strings.EqualFold(name, "daily.cvd"), strings.EqualFold(name, "daily.cld"):
+			versions.Daily = build
+		case strings.EqualFold(name, "bytecode.cvd"), strings.EqualFold(name, "bytecode.cld"):
+			versions.Bytecode = build
+		}
+		if len(fields) == 3 {
+			if t, err := time.Parse("Mon Jan  2 15:04:05 2006", strings.TrimSpace(fields[2])); err == nil {
+				if t.After(newest) {
+					newest = t
+				}
+			}
+		}
+	}
+	versions.ClamAV = engineVersion
+
+	if !newest.IsZero() {
+		ageHours = time.Since(newest).Hours()
+	}
+	return engineVersion, versions, ageHours
+}
+
+func healthHandler(c *gin.Context) {
+	addr := fmt.Sprintf("%s:%s", clamavHost, clamavPort)
+	resp := HealthResponse{ClamAVHost: addr}
+
+	reply, err := clamdPing(addr, clamdDialTimeout)
+	if err != nil {
+		resp.Status = "unhealthy"
+		resp.ClamdReachable = false
+		resp.Error = err.Error()
+		c.JSON(http.StatusServiceUnavailable, resp)
+		return
+	}
+
+	resp.ClamdReachable = true
+	engineVersion, versions, ageHours := parseVersionReply(reply)
+	resp.ClamdVersion = engineVersion
+	resp.SignatureVersions = versions
+	resp.SignatureAgeHours = ageHours
+
+	if ageHours > maxSignatureAgeHours {
+		resp.Status = "degraded"
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	resp.Status = "healthy"
+	c.JSON(http.StatusOK, resp)
+}
+
+func init() {
+	if v := os.Getenv("MAX_SIGNATURE_AGE_HOURS"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			maxSignatureAgeHours = f
+		}
+	}
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -18,9 +19,21 @@ type UpdateResponse struct {
 	Timestamp string `json:"timestamp"`
 }
 
-type HealthResponse struct {
-	Status     string `json:"status"`
-	ClamAVHost string `json:"clamav_host"`
+// TargetUpdateResult is one connection's outcome within a fan-out /update
+// run across the configured BACKUPLENS_CONNECTIONS fleet.
+type TargetUpdateResult struct {
+	Target     string `json:"target"`
+	Success    bool   `json:"success"`
+	Message    string `json:"message"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// MultiUpdateResponse aggregates per-target results; /update returns it
+// whenever BACKUPLENS_CONNECTIONS is configured, with one entry per resolved
+// target (every connection for ?target=all, otherwise just one).
+type MultiUpdateResponse struct {
+	Results   []TargetUpdateResult `json:"results"`
+	Timestamp string               `json:"timestamp"`
 }
 
 var (
@@ -28,127 +41,117 @@ var (
 	clamavPort = "3310"
 )
 
-func healthHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, HealthResponse{
-		Status:     "healthy",
-		ClamAVHost: fmt.Sprintf("%s:%s", clamavHost, clamavPort),
-	})
+// classifyUpdateError inspects freshclam's stderr (not the combined output)
+// and maps it to the UpdateResponse status the handler should return.
+func classifyUpdateError(stderr string) (int, string) {
+	switch {
+	case strings.Contains(stderr, "error code 429") || strings.Contains(stderr, "error code 403"):
+		return http.StatusTooManyRequests, "Rate limited by ClamAV CDN. Please wait before retrying."
+	case strings.Contains(stderr, "OUTDATED"):
+		return http.StatusBadRequest, "ClamAV installation is outdated. Please update the ClamAV container image."
+	case strings.Contains(stderr, "cool-down") || strings.Contains(stderr, "retry-after"):
+		return http.StatusTooManyRequests, "Update on cooldown. Please wait before retrying."
+	default:
+		return http.StatusInternalServerError, "Update failed"
+	}
+}
+
+// runTargetUpdate execs freshclam against a single connection's container
+// and classifies the outcome, for use by both the single-target and
+// fan-out code paths.
+func runTargetUpdate(ctx context.Context, t *targetClient) TargetUpdateResult {
+	start := time.Now()
+	result, err := t.client.ExecRun(ctx, t.conn.Container, []string{"freshclam"})
+	duration := time.Since(start).Milliseconds()
+	if err != nil {
+		return TargetUpdateResult{Target: t.conn.Name, Success: false, Message: err.Error(), DurationMs: duration}
+	}
+	if result.ExitCode != 0 {
+		_, reason := classifyUpdateError(result.Stderr)
+		return TargetUpdateResult{Target: t.conn.Name, Success: false, Message: fmt.Sprintf("%s\n\n%s", reason, result.Stderr), DurationMs: duration}
+	}
+	return TargetUpdateResult{Target: t.conn.Name, Success: true, Message: result.Stdout, DurationMs: duration}
 }
 
-func detectContainerRuntime() string {
-	// Check for Podman binary first (preferred)
-	if _, err := exec.LookPath("podman"); err == nil {
-		// Check if Podman socket is mounted (indicates we're in a Podman environment)
-		// Use a file access check that works even with permission issues
-		_, statErr := os.Stat("/run/podman/podman.sock")
-		// If file exists (even if we can't read it due to permissions), socket is mounted
-		if statErr == nil || !os.IsNotExist(statErr) {
-			// Socket file exists (even if we can't access it), use Podman
-			return "podman"
-		}
-		// Also try Podman for local runs (without socket check)
-		// Try a simple command to see if Podman works
-		cmd := exec.Command("podman", "version", "--format", "{{.Version}}")
-		cmd.Stderr = nil
-		cmd.Stdout = nil
-		if err := cmd.Run(); err == nil {
-			return "podman"
-		}
+// multiUpdateHandler fans freshclam out across every connection resolved
+// from ?target=, running them concurrently and aggregating the results.
+func multiUpdateHandler(c *gin.Context) {
+	targets, err := pool.resolve(c.Query("target"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "targets": pool.list()})
+		return
 	}
 
-	// Fall back to Docker
-	if _, err := exec.LookPath("docker"); err == nil {
-		return "docker"
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Minute)
+	defer cancel()
+
+	results := make([]TargetUpdateResult, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t *targetClient) {
+			defer wg.Done()
+			results[i] = runTargetUpdate(ctx, t)
+		}(i, t)
 	}
+	wg.Wait()
 
-	return ""
+	c.JSON(http.StatusOK, MultiUpdateResponse{
+		Results:   results,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
 }
 
 func updateClamAVHandler(c *gin.Context) {
-	// Detect container runtime (Podman or Docker)
-	runtime := detectContainerRuntime()
-	if runtime == "" {
+	if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		updateStreamHandler(c)
+		return
+	}
+
+	if pool.configured() {
+		multiUpdateHandler(c)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Minute)
+	defer cancel()
+
+	client, err := newContainerClient(ctx)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, UpdateResponse{
 			Success:   false,
-			Message:   "Neither Podman nor Docker found in PATH",
+			Message:   fmt.Sprintf("No reachable container engine: %v", err),
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
 		})
 		return
 	}
 
-	// Execute freshclam in the ClamAV container
-	var cmd *exec.Cmd
-	if runtime == "podman" {
-		// Use Podman - only set CONTAINER_HOST if socket is mounted (in container)
-		// On macOS, Podman uses SSH connections automatically, so don't override
-		cmd = exec.Command("podman", "exec", "clamav", "freshclam")
-		// Check if we're in a container environment with socket mounted
-		_, statErr := os.Stat("/run/podman/podman.sock")
-		if statErr == nil || !os.IsNotExist(statErr) {
-			// Socket exists (even if we can't read it), set CONTAINER_HOST for remote mode
-			// Also disable local storage to avoid overlayfs conflicts
-			cmd.Env = append(os.Environ(),
-				"CONTAINER_HOST=unix:///run/podman/podman.sock",
-				"CONTAINERS_CONF=/dev/null", // Disable local config to force remote mode
-			)
-		}
-		// If socket doesn't exist (local macOS run), Podman will use default connection
-	} else {
-		cmd = exec.Command(runtime, "exec", "clamav", "freshclam")
-	}
-
-	output, err := cmd.CombinedOutput()
-	outputStr := string(output)
-
-	// Check for specific error conditions in output
+	result, err := client.ExecRun(ctx, "clamav", []string{"freshclam"})
 	if err != nil {
-		// Check for rate limiting (429/403 errors)
-		if strings.Contains(outputStr, "error code 429") || strings.Contains(outputStr, "error code 403") {
-			log.Printf("ClamAV update rate limited (%s): %s", runtime, outputStr)
-			c.JSON(http.StatusTooManyRequests, UpdateResponse{
-				Success:   false,
-				Message:   fmt.Sprintf("Rate limited by ClamAV CDN. Please wait before retrying.\n\n%s", outputStr),
-				Timestamp: time.Now().UTC().Format(time.RFC3339),
-			})
-			return
-		}
-
-		// Check for outdated ClamAV version
-		if strings.Contains(outputStr, "OUTDATED") {
-			log.Printf("ClamAV installation is outdated (%s): %s", runtime, outputStr)
-			c.JSON(http.StatusBadRequest, UpdateResponse{
-				Success:   false,
-				Message:   fmt.Sprintf("ClamAV installation is outdated. Please update the ClamAV container image.\n\n%s", outputStr),
-				Timestamp: time.Now().UTC().Format(time.RFC3339),
-			})
-			return
-		}
-
-		// Check for cooldown period
-		if strings.Contains(outputStr, "cool-down") || strings.Contains(outputStr, "retry-after") {
-			log.Printf("ClamAV update on cooldown (%s): %s", runtime, outputStr)
-			c.JSON(http.StatusTooManyRequests, UpdateResponse{
-				Success:   false,
-				Message:   fmt.Sprintf("Update on cooldown. Please wait before retrying.\n\n%s", outputStr),
-				Timestamp: time.Now().UTC().Format(time.RFC3339),
-			})
-			return
-		}
-
-		// Generic error
-		log.Printf("ClamAV update failed (%s): %v, output: %s", runtime, err, outputStr)
+		log.Printf("ClamAV update exec failed (%s): %v", client.Name(), err)
 		c.JSON(http.StatusInternalServerError, UpdateResponse{
 			Success:   false,
-			Message:   fmt.Sprintf("Update failed (%s): %v\n\nOutput: %s", runtime, err, outputStr),
+			Message:   fmt.Sprintf("Update failed (%s): %v", client.Name(), err),
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
 		})
 		return
 	}
 
-	log.Printf("ClamAV database updated successfully (%s): %s", runtime, outputStr)
+	if result.ExitCode != 0 {
+		status, reason := classifyUpdateError(result.Stderr)
+		log.Printf("ClamAV update failed (%s, exit %d): %s", client.Name(), result.ExitCode, result.Stderr)
+		c.JSON(status, UpdateResponse{
+			Success:   false,
+			Message:   fmt.Sprintf("%s\n\n%s", reason, result.Stderr),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		})
+		return
+	}
+
+	log.Printf("ClamAV database updated successfully (%s): %s", client.Name(), result.Stdout)
 	c.JSON(http.StatusOK, UpdateResponse{
 		Success:   true,
-		Message:   fmt.Sprintf("Database updated successfully (%s)\n%s", runtime, outputStr),
+		Message:   fmt.Sprintf("Database updated successfully (%s)\n%s", client.Name(), result.Stdout),
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 	})
 }
@@ -161,6 +164,9 @@ func main() {
 	if p := os.Getenv("CLAMAV_PORT"); p != "" {
 		clamavPort = p
 	}
+	if err := initConnections(); err != nil {
+		log.Fatalf("Failed to load BACKUPLENS_CONNECTIONS: %v", err)
+	}
 
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
@@ -168,6 +174,8 @@ func main() {
 	// Routes
 	r.GET("/health", healthHandler)
 	r.POST("/update", updateClamAVHandler)
+	r.GET("/update/stream", updateStreamHandler)
+	r.POST("/scan", scanHandler)
 
 	port := os.Getenv("PORT")
 	if port == "" {
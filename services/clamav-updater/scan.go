@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxStreamSize mirrors clamd's own StreamMaxLength setting. The body's size
+// is not checked up front: the connection to clamd is opened first, and the
+// scan fails with an error as soon as more than maxStreamSize bytes have
+// been read from the body.
+var maxStreamSize int64 = 25 * 1024 * 1024
+
+const scanChunkSize = 64 * 1024
+
+// ScanResponse is returned by POST /scan.
+type ScanResponse struct {
+	Clean        bool   `json:"clean"`
+	Signature    string `json:"signature,omitempty"`
+	BytesScanned int64  `json:"bytes_scanned"`
+	DurationMs   int64  `json:"duration_ms"`
+}
+
+// instreamScan streams r to clamd using the INSTREAM protocol: a `zINSTREAM`
+// command followed by a series of 4-byte big-endian length-prefixed chunks,
+// terminated by a zero-length chunk. It never buffers the whole body.
+func instreamScan(addr string, r io.Reader, limit int64) (signature string, bytesScanned int64, err error) {
+	conn, err := net.DialTimeout("tcp", addr, clamdDialTimeout)
+	if err != nil {
+		return "", 0, fmt.Errorf("dial %s: %w", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", 0, fmt.Errorf("send INSTREAM: %w", err)
+	}
+
+	buf := make([]byte, scanChunkSize)
+	lenBuf := make([]byte, 4)
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			bytesScanned += int64(n)
+			if limit > 0 && bytesScanned > limit {
+				return "", bytesScanned, fmt.Errorf("stream exceeds MAX_STREAM_SIZE (%d bytes)", limit)
+			}
+			binary.BigEndian.PutUint32(lenBuf, uint32(n))
+			if _, err := conn.Write(lenBuf); err != nil {
+				return "", bytesScanned, fmt.Errorf("send chunk length: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return "", bytesScanned, fmt.Errorf("send chunk: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return "", bytesScanned, fmt.Errorf("read body: %w", readErr)
+		}
+	}
+
+	// Zero-length chunk signals end of stream.
+	binary.BigEndian.PutUint32(lenBuf, 0)
+	if _, err := conn.Write(lenBuf); err != nil {
+		return "", bytesScanned, fmt.Errorf("send end marker: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := reader.ReadString(0)
+	if err != nil {
+		return "", bytesScanned, fmt.Errorf("read response: %w", err)
+	}
+	response = strings.TrimRight(strings.TrimSpace(response), "\x00")
+
+	if strings.HasSuffix(response, "FOUND") {
+		parts := strings.Fields(response)
+		if len(parts) >= 2 {
+			return strings.Join(parts[1:len(parts)-1], " "), bytesScanned, nil
+		}
+		return "Unknown threat", bytesScanned, nil
+	}
+	if strings.HasSuffix(response, "OK") {
+		return "", bytesScanned, nil
+	}
+	return "", bytesScanned, fmt.Errorf("unexpected clamd response: %s", response)
+}
+
+// scanHandler forwards the request body (raw or the first multipart "file"
+// field) to clamd over INSTREAM and reports whether it is clean.
+func scanHandler(c *gin.Context) {
+	var body io.Reader
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to open upload: %v", err)})
+			return
+		}
+		defer f.Close()
+		body = f
+	} else {
+		body = c.Request.Body
+	}
+
+	start := time.Now()
+	addr := fmt.Sprintf("%s:%s", clamavHost, clamavPort)
+	signature, bytesScanned, err := instreamScan(addr, body, maxStreamSize)
+	duration := time.Since(start)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "bytes_scanned": bytesScanned})
+		return
+	}
+
+	c.JSON(http.StatusOK, ScanResponse{
+		Clean:        signature == "",
+		Signature:    signature,
+		BytesScanned: bytesScanned,
+		DurationMs:   duration.Milliseconds(),
+	})
+}
+
+func init() {
+	if v := os.Getenv("MAX_STREAM_SIZE"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			maxStreamSize = n
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// progressFrame is the JSON payload carried by each SSE `progress`/`error`
+// frame so a UI can render a live, ordered log of the freshclam run.
+type progressFrame struct {
+	Stage    string `json:"stage"`
+	Line     string `json:"line"`
+	Sequence int    `json:"sequence"`
+}
+
+// doneFrame is emitted once, as the final SSE event, with the same status
+// classification the buffered /update endpoint returns.
+type doneFrame struct {
+	Status    string `json:"status"` // success | rate_limited | cooldown | outdated | error
+	Message   string `json:"message"`
+	Timestamp string `json:"timestamp"`
+}
+
+func writeSSE(w http.ResponseWriter, flusher http.Flusher, event string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("update stream: failed to marshal %s frame: %v", event, err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	flusher.Flush()
+}
+
+// classifyDoneStatus maps an ExecResult to the same status vocabulary the
+// buffered handler uses, so SSE clients can reuse their existing state
+// machine instead of re-parsing free text.
+func classifyDoneStatus(result *ExecResult) (status, message string) {
+	if result.ExitCode == 0 {
+		return "success", "Database updated successfully"
+	}
+	switch {
+	case strings.Contains(result.Stderr, "error code 429") || strings.Contains(result.Stderr, "error code 403"):
+		return "rate_limited", "Rate limited by ClamAV CDN. Please wait before retrying."
+	case strings.Contains(result.Stderr, "OUTDATED"):
+		return "outdated", "ClamAV installation is outdated. Please update the ClamAV container image."
+	case strings.Contains(result.Stderr, "cool-down") || strings.Contains(result.Stderr, "retry-after"):
+		return "cooldown", "Update on cooldown. Please wait before retrying."
+	default:
+		return "error", "Update failed"
+	}
+}
+
+// updateStreamHandler runs freshclam through the container-engine bindings
+// and streams its output to the caller as Server-Sent Events, instead of
+// buffering the whole run behind a single JSON response.
+func updateStreamHandler(c *gin.Context) {
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, UpdateResponse{
+			Success: false,
+			Message: "streaming not supported by this response writer",
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Minute)
+	defer cancel()
+
+	client, err := newContainerClient(ctx)
+	if err != nil {
+		writeSSE(c.Writer, flusher, "error", progressFrame{Stage: "connect", Line: err.Error()})
+		writeSSE(c.Writer, flusher, "done", doneFrame{Status: "error", Message: err.Error(), Timestamp: time.Now().UTC().Format(time.RFC3339)})
+		return
+	}
+
+	stream, err := client.ExecStream(ctx, "clamav", []string{"freshclam"})
+	if err != nil {
+		writeSSE(c.Writer, flusher, "error", progressFrame{Stage: "exec", Line: err.Error()})
+		writeSSE(c.Writer, flusher, "done", doneFrame{Status: "error", Message: err.Error(), Timestamp: time.Now().UTC().Format(time.RFC3339)})
+		return
+	}
+
+	seq := 0
+	scanner := bufio.NewScanner(stream.Output)
+	for scanner.Scan() {
+		seq++
+		writeSSE(c.Writer, flusher, "progress", progressFrame{Stage: "freshclam", Line: scanner.Text(), Sequence: seq})
+	}
+	if err := scanner.Err(); err != nil {
+		seq++
+		writeSSE(c.Writer, flusher, "error", progressFrame{Stage: "freshclam", Line: err.Error(), Sequence: seq})
+	}
+
+	result, err := stream.Wait()
+	if err != nil {
+		writeSSE(c.Writer, flusher, "done", doneFrame{Status: "error", Message: err.Error(), Timestamp: time.Now().UTC().Format(time.RFC3339)})
+		return
+	}
+
+	status, message := classifyDoneStatus(result)
+	log.Printf("ClamAV update stream finished (%s): %s", client.Name(), status)
+	writeSSE(c.Writer, flusher, "done", doneFrame{Status: status, Message: message, Timestamp: time.Now().UTC().Format(time.RFC3339)})
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tarBlockSize is the fixed block size tar headers and padding are aligned
+// to; used to size the zero-padding we write back during reassembly.
+const tarBlockSize = 512
+
+// inlinePayloadLimit is the largest entry payload we embed directly in the
+// manifest rather than spilling to its own file in the payload store.
+const inlinePayloadLimit = 4096
+
+// isTarArchive reports whether path looks like a tar, tar.gz or tar.zst
+// archive, which is common packaging for pgBackRest and other backup
+// tooling and deserves per-entry scanning instead of one blob-level score.
+func isTarArchive(path string) bool {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".tar"),
+		strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"),
+		strings.HasSuffix(lower, ".tar.zst"):
+		return true
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 6)
+	n, _ := io.ReadFull(f, magic)
+	format := detectCompression(magic[:n])
+	if format != "gzip" && format != "zstd" {
+		return false
+	}
+	// A gzip or zstd stream counts only when it wraps a tar stream ("ustar"
+	// magic at offset 257 of the first header block).
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	body, err := decompressStream(format, f)
+	if err != nil {
+		return false
+	}
+	header := make([]byte, 262)
+	_, err = io.ReadFull(body, header)
+	return err == nil && bytes.Equal(header[257:], []byte("ustar"))
+}
+
+// ManifestEntry captures one tar entry's raw on-disk framing so the archive
+// can be reassembled byte-for-byte: the raw header block(s) exactly as they
+// appeared in the stream, a reference to (or inline copy of) the payload
+// bytes, and the padding bytes that followed the payload up to the next
+// 512-byte boundary (for the last entry, also the end-of-archive blocks and
+// anything after them).
+type ManifestEntry struct {
+	Name         string
+	HeaderBytes  []byte
+	PayloadSize  int64
+	PayloadPath  string // set when the payload was spilled to the payload store
+	PayloadBytes []byte // set when the payload was small enough to inline
+	PaddingBytes []byte
+}
+
+// AssemblyManifest is the tar-split-style sidecar that lets the pipeline
+// rebuild an archive's tar stream exactly as it arrived, or with one
+// offending entry zeroed out, without disturbing anything else's offsets or
+// metadata. SourceSHA256 is the digest of the source file; nothing in the
+// pipeline hashes a rebuilt archive to compare against it. For a compressed
+// source the rebuild is the uncompressed tar stream.
+type AssemblyManifest struct {
+	SourceSHA256 string
+	Entries      []ManifestEntry
+}
+
+func writeLP(w io.Writer, b []byte) error {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(b)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readLP(r io.Reader) ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// writeManifest serializes the manifest as a sequence of length-prefixed
+// segments per entry: name, raw_header_bytes, a one-byte inline flag
+// followed by either the inline payload or its reference path, and
+// padding_bytes.
+func writeManifest(path string, m *AssemblyManifest) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create manifest: %w", err)
+	}
+	defer f.Close()
+
+	if err := writeLP(f, []byte(m.SourceSHA256)); err != nil {
+		return err
+	}
+	var countBuf [4]byte
+	binary.BigEndian.PutUint32(countBuf[:], uint32(len(m.Entries)))
+	if _, err := f.Write(countBuf[:]); err != nil {
+		return err
+	}
+
+	for _, e := range m.Entries {
+		if err := writeLP(f, []byte(e.Name)); err != nil {
+			return err
+		}
+		if err := writeLP(f, e.HeaderBytes); err != nil {
+			return err
+		}
+		var sizeBuf [8]byte
+		binary.BigEndian.PutUint64(sizeBuf[:], uint64(e.PayloadSize))
+		if _, err := f.Write(sizeBuf[:]); err != nil {
+			return err
+		}
+		if e.PayloadPath != "" {
+			if _, err := f.Write([]byte{0}); err != nil {
+				return err
+			}
+			if err := writeLP(f, []byte(e.PayloadPath)); err != nil {
+				return err
+			}
+		} else {
+			if _, err := f.Write([]byte{1}); err != nil {
+				return err
+			}
+			if err := writeLP(f, e.PayloadBytes); err != nil {
+				return err
+			}
+		}
+		if err := writeLP(f, e.PaddingBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readManifest(path string) (*AssemblyManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open manifest: %w", err)
+	}
+	defer f.Close()
+
+	shaBytes, err := readLP(f)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest sha256: %w", err)
+	}
+	var countBuf [4]byte
+	if _, err := io.ReadFull(f, countBuf[:]); err != nil {
+		return nil, fmt.Errorf("read manifest entry count: %w", err)
+	}
+	count := binary.BigEndian.Uint32(countBuf[:])
+
+	m := &AssemblyManifest{SourceSHA256: string(shaBytes)}
+	for i := uint32(0); i < count; i++ {
+		name, err := readLP(f)
+		if err != nil {
+			return nil, err
+		}
+		header, err := readLP(f)
+		if err != nil {
+			return nil, err
+		}
+		var sizeBuf [8]byte
+		if _, err := io.ReadFull(f, sizeBuf[:]); err != nil {
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint64(sizeBuf[:]))
+
+		var inlineFlag [1]byte
+		if _, err := io.ReadFull(f, inlineFlag[:]); err != nil {
+			return nil, err
+		}
+		entry := ManifestEntry{Name: string(name), HeaderBytes: header, PayloadSize: size}
+		if inlineFlag[0] == 0 {
+			ref, err := readLP(f)
+			if err != nil {
+				return nil, err
+			}
+			entry.PayloadPath = string(ref)
+		} else {
+			payload, err := readLP(f)
+			if err != nil {
+				return nil, err
+			}
+			entry.PayloadBytes = payload
+		}
+		padding, err := readLP(f)
+		if err != nil {
+			return nil, err
+		}
+		entry.PaddingBytes = padding
+		m.Entries = append(m.Entries, entry)
+	}
+	return m, nil
+}
+
+// teeOffsetReader wraps an io.Reader and records every byte read, so the
+// caller can slice out exactly the bytes consumed between two checkpoints
+// (e.g. "everything archive/tar read while parsing one header").
+type teeOffsetReader struct {
+	r   io.Reader
+	buf bytes.Buffer
+}
+
+func (t *teeOffsetReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// extractTarWithManifest walks a tar stream, extracting each entry's
+// payload into payloadDir and recording the exact raw header and padding
+// bytes it consumed, so the archive can later be rebuilt byte-for-byte.
+func extractTarWithManifest(r io.Reader, payloadDir string) (*AssemblyManifest, error) {
+	if err := os.MkdirAll(payloadDir, 0755); err != nil {
+		return nil, fmt.Errorf("create payload dir: %w", err)
+	}
+
+	tee := &teeOffsetReader{r: r}
+	tr := tar.NewReader(tee)
+
+	manifest := &AssemblyManifest{}
+
+	// pending holds the previous entry, still missing its PaddingBytes:
+	// tar.Reader skips an entry's padding itself, internally, the next time
+	// Next() is called -- not before -- so we can only learn how many
+	// padding bytes actually separated it from the next header by watching
+	// what the tee picks up during that next Next() call, rather than
+	// skipping ahead by hand (which would desync the tee from the stream
+	// tar.Reader thinks it's reading).
+	var pending *ManifestEntry
+	var pendingPadLen int
+
+	for i := 0; ; i++ {
+		// The tee buffer is reset right after the previous entry's payload
+		// was copied out, so at this point it holds exactly: the previous
+		// entry's padding, followed by whatever tr.Next() is about to read
+		// for this entry (including any PAX/GNU long-name extension headers
+		// tar.Reader consumes internally).
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			// Pull the rest of the stream (record padding after the
+			// end-of-archive blocks) through the tee as well, so the last
+			// entry's padding carries the whole archive trailer.
+			io.Copy(io.Discard, tee)
+		}
+
+		if pending != nil {
+			consumed := tee.buf.Bytes()
+			padEnd := pendingPadLen
+			if err == io.EOF || padEnd > len(consumed) {
+				padEnd = len(consumed)
+			}
+			pending.PaddingBytes = append([]byte(nil), consumed[:padEnd]...)
+			manifest.Entries = append(manifest.Entries, *pending)
+			pending = nil
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read tar header: %w", err)
+		}
+
+		headerStart := pendingPadLen
+		if headerStart > tee.buf.Len() {
+			headerStart = tee.buf.Len()
+		}
+		rawHeader := append([]byte(nil), tee.buf.Bytes()[headerStart:]...)
+		tee.buf.Reset()
+
+		payloadPath := filepath.Join(payloadDir, fmt.Sprintf("%04d.payload", i))
+		payloadFile, err := os.Create(payloadPath)
+		if err != nil {
+			return nil, fmt.Errorf("create payload file: %w", err)
+		}
+		written, err := io.Copy(payloadFile, tr)
+		payloadFile.Close()
+		if err != nil {
+			return nil, fmt.Errorf("extract entry %q: %w", hdr.Name, err)
+		}
+		tee.buf.Reset()
+
+		entry := ManifestEntry{
+			Name:        hdr.Name,
+			HeaderBytes: rawHeader,
+			PayloadSize: written,
+		}
+		if written <= inlinePayloadLimit {
+			data, err := os.ReadFile(payloadPath)
+			if err != nil {
+				return nil, err
+			}
+			entry.PayloadBytes = data
+			os.Remove(payloadPath)
+		} else {
+			entry.PayloadPath = payloadPath
+		}
+
+		pending = &entry
+		pendingPadLen = int((tarBlockSize - (written % tarBlockSize)) % tarBlockSize)
+	}
+
+	return manifest, nil
+}
+
+// rebuildArchive writes the archive back out from the manifest and payload
+// store, optionally replacing one entry's payload with zero bytes of the
+// same length (a "redaction stub") while leaving every other entry's
+// offsets, headers and padding untouched.
+func rebuildArchive(m *AssemblyManifest, outPath, redactEntry string) error {
+	out, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("create rebuilt archive: %w", err)
+	}
+	defer out.Close()
+
+	for _, e := range m.Entries {
+		if _, err := out.Write(e.HeaderBytes); err != nil {
+			return err
+		}
+
+		if e.Name == redactEntry {
+			if _, err := out.Write(make([]byte, e.PayloadSize)); err != nil {
+				return err
+			}
+		} else if e.PayloadPath != "" {
+			payload, err := os.Open(e.PayloadPath)
+			if err != nil {
+				return fmt.Errorf("open payload for %q: %w", e.Name, err)
+			}
+			_, err = io.Copy(out, payload)
+			payload.Close()
+			if err != nil {
+				return err
+			}
+		} else {
+			if _, err := out.Write(e.PayloadBytes); err != nil {
+				return err
+			}
+		}
+
+		if _, err := out.Write(e.PaddingBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sha256File hashes a file's contents. It is used for the cache key of a
+// scanned file and for an archive manifest's SourceSHA256; it is not run on
+// a rebuilt archive.
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// openArchiveStream opens path and, if its magic bytes show a gzip, zstd or
+// xz wrapper, unwraps it with the compressed-stream layer's decompressStream
+// so the tar reader always sees a raw tar byte stream.
+func openArchiveStream(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 6)
+	n, _ := io.ReadFull(f, magic)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	format := detectCompression(magic[:n])
+	if format == "" {
+		return f, nil
+	}
+	body, err := decompressStream(format, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{body, f}, nil
+}
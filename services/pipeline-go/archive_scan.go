@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// processArchiveFile scans a tar/tar.gz/tar.zst archive entry-by-entry
+// instead of treating the whole blob as one high-entropy binary: each
+// member gets its own MIME/ClamAV/YARA/entropy pass and decision. While
+// walking the archive it also writes a tar-split-style assembly manifest so
+// a quarantined entry can be redacted and the rest of the archive rebuilt
+// byte-for-byte.
+func processArchiveFile(path string, config *ScoringConfig, scanners []Scanner, scannerTimeout time.Duration, quarantineDir string) *ScanResult {
+	result := &ScanResult{Path: path}
+
+	stream, err := openArchiveStream(path)
+	if err != nil {
+		result.Error = fmt.Errorf("open archive: %w", err)
+		return result
+	}
+	defer stream.Close()
+
+	payloadDir, err := os.MkdirTemp("", "archive-payloads-*")
+	if err != nil {
+		result.Error = fmt.Errorf("create payload dir: %w", err)
+		return result
+	}
+	defer os.RemoveAll(payloadDir)
+
+	manifest, err := extractTarWithManifest(stream, payloadDir)
+	if err != nil {
+		result.Error = fmt.Errorf("extract archive: %w", err)
+		return result
+	}
+
+	if sum, err := sha256File(path); err == nil {
+		manifest.SourceSHA256 = sum
+	} else {
+		log.Printf("[%s] Warning: failed to hash archive: %v", filepath.Base(path), err)
+	}
+
+	var quarantinedEntry string
+	for _, entry := range manifest.Entries {
+		entryPath := entry.PayloadPath
+		if entryPath == "" {
+			// Small entries were inlined into the manifest; scan them from a
+			// throwaway temp file since ClamAV/YARA need a path.
+			tmp, err := os.CreateTemp(payloadDir, "inline-*")
+			if err != nil {
+				log.Printf("[%s] Warning: failed to materialize inline entry %q: %v", filepath.Base(path), entry.Name, err)
+				continue
+			}
+			tmp.Write(entry.PayloadBytes)
+			tmp.Close()
+			entryPath = tmp.Name()
+		}
+
+		entryResult := scanArchiveEntry(entry.Name, entryPath, config, scanners, scannerTimeout)
+		result.Entries = append(result.Entries, entryResult)
+		log.Printf("[%s] entry %q: MIME=%s ClamAV=%s YARA=%d Entropy=%.3f Score=%d Decision=%s",
+			filepath.Base(path), entry.Name, entryResult.MimeType, entryResult.ClamAVResult,
+			len(entryResult.YaraMatches), entryResult.Entropy, entryResult.Score, entryResult.Decision)
+
+		if entryResult.Decision == "quarantine" && quarantinedEntry == "" {
+			quarantinedEntry = entry.Name
+		}
+		if entryResult.Score > result.Score {
+			result.Score = entryResult.Score
+		}
+	}
+
+	manifestPath := filepath.Join(quarantineDir, filepath.Base(path)+".manifest")
+	if err := writeManifest(manifestPath, manifest); err != nil {
+		log.Printf("[%s] Warning: failed to write assembly manifest: %v", filepath.Base(path), err)
+	} else {
+		result.ManifestPath = manifestPath
+	}
+
+	switch {
+	case quarantinedEntry != "":
+		result.Decision = "quarantine"
+		sanitized := filepath.Join(quarantineDir, filepath.Base(path))
+		if err := rebuildArchive(manifest, sanitized, quarantinedEntry); err != nil {
+			result.Error = fmt.Errorf("failed to rebuild sanitized archive: %w", err)
+		} else {
+			log.Printf("[%s] Quarantined entry %q; sanitized archive -> %s", filepath.Base(path), quarantinedEntry, sanitized)
+		}
+		evidenceDir := filepath.Join(quarantineDir, "evidence")
+		if err := os.MkdirAll(evidenceDir, 0700); err == nil {
+			evidencePath := filepath.Join(evidenceDir, filepath.Base(path))
+			if err := os.Rename(path, evidencePath); err != nil {
+				log.Printf("[%s] Warning: failed to move original into evidence area: %v", filepath.Base(path), err)
+			} else {
+				os.Chmod(evidencePath, 0400)
+			}
+		}
+	case result.Score >= config.Thresholds.ManualReview:
+		result.Decision = "manual_review"
+	default:
+		result.Decision = "auto_approve"
+	}
+
+	return result
+}
+
+// scanArchiveEntry runs the same pluggable scanner pipeline used for
+// standalone files against one extracted archive member. Entries don't carry
+// their own sliding-window localization pass (they're already
+// archive-member-sized, not multi-GB streams), so the entropy scanner sees
+// only the single-sample RawEntropy.
+func scanArchiveEntry(name, extractedPath string, config *ScoringConfig, scanners []Scanner, scannerTimeout time.Duration) *ScanResult {
+	result := &ScanResult{Path: name}
+
+	mt, err := mimetype.DetectFile(extractedPath)
+	if err != nil {
+		result.Error = fmt.Errorf("MIME detection failed: %w", err)
+		return result
+	}
+	result.MimeType = mt.String()
+
+	if f, err := os.Open(extractedPath); err == nil {
+		buf := make([]byte, 65536)
+		n, _ := f.Read(buf)
+		result.RawEntropy = entropy(buf[:n])
+		result.Entropy = result.RawEntropy
+		f.Close()
+	}
+
+	meta := FileMeta{
+		Path:       extractedPath,
+		MimeType:   result.MimeType,
+		RawEntropy: result.RawEntropy,
+	}
+	signals, scanStats := runScanners(context.Background(), scanners, meta, scannerTimeout)
+	result.Signals = signals
+	result.ScannerStats = scanStats
+	populateLegacyFields(result)
+	for _, stat := range scanStats {
+		if stat.Err != nil {
+			log.Printf("[%s] %s scanner warning: %v (%dms, scanning continues)", name, stat.Name, stat.Err, stat.DurationMs)
+		}
+	}
+
+	result.Score, result.Decision = scoreSignals(signals, config)
+
+	return result
+}
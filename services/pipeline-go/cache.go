@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// pipelineVersion is bumped whenever scoring-relevant scan logic changes in
+// a way that should invalidate cached decisions even if the signals (MIME,
+// matches, entropy) would otherwise still be considered fresh.
+const pipelineVersion = "1"
+
+// EngineVersions pins a cache entry to the signature/ruleset state that
+// produced it, so an advancing ClamAV database or YARA ruleset invalidates
+// stale decisions instead of silently reusing them.
+type EngineVersions struct {
+	ClamAVSigVersion string `json:"clamav_sig_version"`
+	YaraRulesetHash  string `json:"yara_ruleset_hash"`
+	PipelineVersion  string `json:"pipeline_version"`
+}
+
+func (a EngineVersions) matches(b EngineVersions) bool {
+	return a == b
+}
+
+// CacheEntry is one record in the content-addressable scan cache, keyed by
+// the SHA-256 of the file's bytes. Signals holds the raw, re-scoreable
+// per-scanner output (independent of the thresholds that turned them into a
+// Decision), so --rescore can replay scoreSignals against a possibly-updated
+// ScoringConfig without re-running any scanner.
+type CacheEntry struct {
+	SHA256    string         `json:"sha256"`
+	MimeType  string         `json:"mime_type"`
+	Signals   Signals        `json:"signals"`
+	Score     int            `json:"score"`
+	Decision  string         `json:"decision"`
+	ScannedAt time.Time      `json:"scanned_at"`
+	Versions  EngineVersions `json:"engine_versions"`
+}
+
+// ScanCache is a small JSON-on-disk index protected by a RWMutex: simple
+// enough for the pipeline's access pattern (many reads, occasional writes,
+// no need for transactions across processes) without pulling in bbolt.
+type ScanCache struct {
+	mu      sync.RWMutex
+	path    string
+	entries map[string]CacheEntry
+}
+
+// loadScanCache reads the on-disk index at path, if it exists, or starts
+// with an empty cache otherwise.
+func loadScanCache(path string) (*ScanCache, error) {
+	c := &ScanCache{path: path, entries: map[string]CacheEntry{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read scan cache: %w", err)
+	}
+	if len(data) == 0 {
+		return c, nil
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("parse scan cache: %w", err)
+	}
+	return c, nil
+}
+
+// get returns the cached entry for sha, if one exists and its engine
+// versions haven't been superseded by current, so the caller can
+// short-circuit to the cached decision.
+func (c *ScanCache) get(sha string, current EngineVersions) (CacheEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[sha]
+	if !ok || !entry.Versions.matches(current) {
+		return CacheEntry{}, false
+	}
+	return entry, true
+}
+
+// put stores (or replaces) the entry for sha and persists the index.
+func (c *ScanCache) put(entry CacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[entry.SHA256] = entry
+	return c.saveLocked()
+}
+
+func (c *ScanCache) saveLocked() error {
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal scan cache: %w", err)
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write scan cache: %w", err)
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// currentEngineVersions reads the signature/ruleset versions the running
+// pipeline is scoring against. ClamAV and YARA don't expose these over the
+// same endpoints pipeline-go already calls, so operators pin them via env
+// vars that their update jobs (clamav-updater, yara-scanner /reload) bump.
+func currentEngineVersions() EngineVersions {
+	return EngineVersions{
+		ClamAVSigVersion: envOrDefault("CLAMAV_SIG_VERSION", "unknown"),
+		YaraRulesetHash:  envOrDefault("YARA_RULESET_HASH", "unknown"),
+		PipelineVersion:  pipelineVersion,
+	}
+}
+
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// scoreSignals applies ScoringConfig's per-signal weights and thresholds to
+// a merged Signals map, shared by the live scan path and --rescore so
+// threshold tuning can be replayed against cached signals without
+// re-running any scanner. A signal's weight applies once for a bool true,
+// or is multiplied by the value for a numeric signal (e.g.
+// "yara.match_count" contributes weight-per-match); signals the config
+// doesn't assign a weight to are ignored, which is how a newly registered
+// scanner stays silent until its keys are given a weight.
+func scoreSignals(signals Signals, config *ScoringConfig) (score int, decision string) {
+	for key, weight := range config.Weights {
+		v, ok := signals[key]
+		if !ok {
+			continue
+		}
+		switch val := v.(type) {
+		case bool:
+			if val {
+				score += weight
+			}
+		case int:
+			score += weight * val
+		case int64:
+			score += weight * int(val)
+		case float64:
+			// JSON round-trips through the on-disk cache turn every number
+			// into a float64, so this also covers int-valued signals read
+			// back from a cache entry.
+			score += weight * int(val)
+		}
+	}
+
+	switch {
+	case score >= config.Thresholds.Quarantine:
+		decision = "quarantine"
+	case score >= config.Thresholds.ManualReview:
+		decision = "manual_review"
+	default:
+		decision = "auto_approve"
+	}
+	return score, decision
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// Sliding-window entropy localization parameters. A single 64KiB sample (the
+// existing RawEntropy/DecompressedEntropy) collapses any local high-entropy
+// region in a multi-GB backup into the average; this walks the whole stream
+// in overlapping windows so an embedded encrypted payload shows up as a
+// region instead of disappearing into the mean.
+const (
+	entropyWindow         = 4096
+	entropyStride         = 1024
+	entropyRegionMinRun   = 3    // consecutive windows above threshold to call it a region
+	entropyRegionMergeGap = 2048 // merge regions separated by less than this many bytes
+)
+
+// EntropyPoint is one (offset, entropy) sample from slidingWindowEntropy.
+type EntropyPoint struct {
+	Offset  int64
+	Entropy float64
+}
+
+// EntropyRegion is a contiguous run of high-entropy windows, merged with
+// nearby runs, reported so an analyst can `dd` the suspicious byte range out
+// of a backup for further inspection.
+type EntropyRegion struct {
+	Start       int64
+	End         int64
+	MeanEntropy float64
+}
+
+// entropyFromFreq computes Shannon entropy from a running 256-bucket byte
+// frequency histogram in O(256), independent of the window size it was
+// accumulated over.
+func entropyFromFreq(freq [256]int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	var ent float64
+	ln := float64(total)
+	for _, c := range freq {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / ln
+		ent -= p * math.Log2(p)
+	}
+	return ent
+}
+
+// slidingWindowEntropy walks r in windows of `window` bytes that advance by
+// `stride` bytes, maintaining an incremental byte-frequency histogram:
+// each step decrements counts for the stride bytes leaving the window and
+// increments counts for the stride bytes entering it, then recomputes
+// entropy from the histogram rather than rescanning the whole window.
+func slidingWindowEntropy(r io.Reader, window, stride int) ([]EntropyPoint, error) {
+	if window <= 0 || stride <= 0 || stride > window {
+		return nil, fmt.Errorf("invalid window/stride: %d/%d", window, stride)
+	}
+
+	buf := make([]byte, window)
+	filled, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if filled == 0 {
+			return nil, nil
+		}
+		var freq [256]int
+		for _, b := range buf[:filled] {
+			freq[b]++
+		}
+		return []EntropyPoint{{Offset: 0, Entropy: entropyFromFreq(freq, filled)}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var freq [256]int
+	for _, b := range buf {
+		freq[b]++
+	}
+
+	points := []EntropyPoint{{Offset: 0, Entropy: entropyFromFreq(freq, window)}}
+
+	step := make([]byte, stride)
+	cursor := 0
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, step)
+		for i := 0; i < n; i++ {
+			leaving := buf[(cursor+i)%window]
+			freq[leaving]--
+			entering := step[i]
+			freq[entering]++
+			buf[(cursor+i)%window] = entering
+		}
+		if n > 0 {
+			cursor = (cursor + n) % window
+			offset += int64(n)
+			points = append(points, EntropyPoint{Offset: offset, Entropy: entropyFromFreq(freq, window)})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return points, nil
+}
+
+// detectEntropyRegions finds contiguous runs of at least minRun windows
+// whose entropy exceeds threshold, then merges runs separated by less than
+// mergeGap bytes into a single region.
+func detectEntropyRegions(points []EntropyPoint, window int, threshold float64, minRun int, mergeGap int64) []EntropyRegion {
+	var regions []EntropyRegion
+
+	runStart := -1
+	var runSum float64
+	var runCount int
+	flush := func(endIdx int) {
+		if runStart == -1 {
+			return
+		}
+		if runCount >= minRun {
+			regions = append(regions, EntropyRegion{
+				Start:       points[runStart].Offset,
+				End:         points[endIdx].Offset + int64(window),
+				MeanEntropy: runSum / float64(runCount),
+			})
+		}
+		runStart = -1
+		runSum = 0
+		runCount = 0
+	}
+
+	for i, p := range points {
+		if p.Entropy > threshold {
+			if runStart == -1 {
+				runStart = i
+			}
+			runSum += p.Entropy
+			runCount++
+		} else {
+			flush(i - 1)
+		}
+	}
+	flush(len(points) - 1)
+
+	if len(regions) == 0 {
+		return regions
+	}
+	merged := []EntropyRegion{regions[0]}
+	for _, r := range regions[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start-last.End < mergeGap {
+			last.End = r.End
+			last.MeanEntropy = (last.MeanEntropy + r.MeanEntropy) / 2
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -20,19 +22,28 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-// ScoringConfig holds the scoring weights and thresholds
+// ScoringConfig holds the scoring weights and thresholds. Weights is keyed
+// by dotted signal name (e.g. "clamav.infected", "yara.match_count",
+// "entropy.high", "ssdeep.blocklist_match") rather than a fixed struct, so a
+// newly registered Scanner contributes to scoring purely by adding a key
+// here — no code change required.
 type ScoringConfig struct {
-	Weights struct {
-		MimeMismatch   int `yaml:"mime_mismatch"`
-		ClamavInfected int `yaml:"clamav_infected"`
-		YaraMatch      int `yaml:"yara_match"`
-		HighEntropy    int `yaml:"high_entropy"`
-	} `yaml:"weights"`
+	Weights    map[string]int `yaml:"weights"`
 	Thresholds struct {
 		AutoApprove  int `yaml:"auto_approve"`
 		ManualReview int `yaml:"manual_review"`
 		Quarantine   int `yaml:"quarantine"`
 	} `yaml:"thresholds"`
+
+	// SsdeepBlocklist is a list of known-bad CTPH digests the ssdeep scanner
+	// fuzzy-matches every scanned file against.
+	SsdeepBlocklist []string `yaml:"ssdeep_blocklist"`
+	// SsdeepThreshold is the minimum ssdeep.Distance similarity score (0-100)
+	// to call a file a blocklist match. Defaults to 70 if unset.
+	SsdeepThreshold int `yaml:"ssdeep_threshold"`
+	// ScannerTimeoutSeconds bounds how long any single registered Scanner
+	// may run against one file before it's canceled. Defaults to 30s.
+	ScannerTimeoutSeconds int `yaml:"scanner_timeout_seconds"`
 }
 
 // ScanResult holds the results of scanning a file
@@ -45,6 +56,67 @@ type ScanResult struct {
 	Score        int
 	Decision     string
 	Error        error
+
+	// Signals holds every registered Scanner's merged output for this file,
+	// keyed by dotted signal name; it's what scoreSignals actually scores.
+	// ClamAVResult/YaraMatches above are kept in sync from it for logging
+	// and back-compat, since most of the codebase reads them directly.
+	Signals Signals
+	// ScannerStats records each registered Scanner's latency and any error,
+	// so a slow or crashing engine is visible per-file instead of only in
+	// aggregate logs.
+	ScannerStats []ScannerResult
+
+	// CompressionFormat is set to "gzip", "zstd" or "xz" when the file was
+	// detected as a transparently-compressed stream; empty otherwise.
+	CompressionFormat string
+	// RawEntropy is the entropy of the bytes on disk. For a compressed file
+	// this is near-meaningless (compressors produce ~8 bits/byte output
+	// regardless of payload) and is kept only for visibility in logs.
+	RawEntropy float64
+	// DecompressedEntropy is the entropy of the underlying plaintext once
+	// any compression wrapper has been peeled off; this is what scoring
+	// uses so a well-compressed legitimate backup doesn't look the same as
+	// an encrypted or already-compressed payload smuggled inside one.
+	DecompressedEntropy float64
+
+	// Entries holds one ScanResult per archive member when Path is a
+	// tar/tar.gz/tar.zst archive, so a single high-entropy blob doesn't hide
+	// an infected file nested inside an otherwise clean backup.
+	Entries []*ScanResult
+	// ManifestPath points at the tar-split-style assembly manifest written
+	// alongside a scanned archive, used to rebuild it byte-for-byte or with
+	// a quarantined entry redacted.
+	ManifestPath string
+
+	// EntropyRegions holds contiguous byte ranges where the sliding-window
+	// entropy sample exceeded the high-entropy threshold for multiple
+	// consecutive windows, pinpointing embedded encrypted/compressed
+	// payloads that a single whole-file entropy sample would average away.
+	EntropyRegions []EntropyRegion
+}
+
+// populateLegacyFields fills ClamAVResult/YaraMatches from result.Signals,
+// so code that reads those fields directly (archive logging, the final
+// per-file log line) works whether Signals came from a live scan or a cache
+// hit. A cache-hit's Signals round-tripped through JSON, so a []string
+// comes back as []any — handled alongside the live-scan []string case.
+func populateLegacyFields(result *ScanResult) {
+	if v, ok := result.Signals["clamav.signature"].(string); ok {
+		result.ClamAVResult = v
+	}
+	switch v := result.Signals["yara.matches"].(type) {
+	case []string:
+		result.YaraMatches = v
+	case []any:
+		matches := make([]string, 0, len(v))
+		for _, m := range v {
+			if s, ok := m.(string); ok {
+				matches = append(matches, s)
+			}
+		}
+		result.YaraMatches = matches
+	}
 }
 
 // Simple Shannon entropy
@@ -68,7 +140,41 @@ func entropy(b []byte) float64 {
 	return ent
 }
 
+// scanClamAV opens path and streams it to clamd over INSTREAM. If the file
+// is a transparently-compressed wrapper (gzip/zstd/xz), the plaintext is
+// decompressed lazily into the chunk loop below rather than written to disk
+// first, so ClamAV sees the payload it would actually need to catch malware
+// in, not the compressed bytes.
 func scanClamAV(path string, clamdAddr string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	peek := make([]byte, 6)
+	n, _ := io.ReadFull(f, peek)
+	peek = peek[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind file: %w", err)
+	}
+
+	var body io.Reader = f
+	if format := detectCompression(peek); format != "" {
+		decompressed, err := decompressStream(format, f)
+		if err != nil {
+			return "", fmt.Errorf("failed to open %s stream: %w", format, err)
+		}
+		body = decompressed
+	}
+
+	return scanClamAVStream(body, clamdAddr)
+}
+
+// scanClamAVStream streams an already-decoded byte stream to clamd over
+// INSTREAM; it is the shared body used by both plain file scans and
+// compressed-file scans.
+func scanClamAVStream(body io.Reader, clamdAddr string) (string, error) {
 	// Parse address (format: tcp://host:port)
 	addr := strings.TrimPrefix(clamdAddr, "tcp://")
 	if addr == clamdAddr {
@@ -95,17 +201,10 @@ func scanClamAV(path string, clamdAddr string) (string, error) {
 		return "", fmt.Errorf("failed to send INSTREAM command: %w", err)
 	}
 
-	// Read file
-	f, err := os.Open(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open file: %w", err)
-	}
-	defer f.Close()
-
 	// Send file data in chunks
 	buf := make([]byte, 4096)
 	for {
-		n, err := f.Read(buf)
+		n, err := body.Read(buf)
 		if n > 0 {
 			// Send chunk size (network byte order, 4 bytes)
 			chunkSize := uint32(n)
@@ -182,10 +281,26 @@ func scanClamAV(path string, clamdAddr string) (string, error) {
 	return "", fmt.Errorf("unexpected response: %s", response)
 }
 
-func scanYARA(path string, yaraHost string, yaraPort string) ([]string, error) {
+// entropyRegionHint is the wire shape of an EntropyRegion sent to the YARA
+// scanner as a scan hint; trimmed to just what a scanner would need to
+// prioritize a byte range, not the pipeline's internal representation.
+type entropyRegionHint struct {
+	Start       int64   `json:"start"`
+	End         int64   `json:"end"`
+	MeanEntropy float64 `json:"mean_entropy"`
+}
+
+func scanYARA(path string, yaraHost string, yaraPort string, regions []EntropyRegion) ([]string, error) {
 	url := fmt.Sprintf("http://%s:%s/scan-file", yaraHost, yaraPort)
 
-	reqBody := map[string]string{"path": path}
+	hints := make([]entropyRegionHint, 0, len(regions))
+	for _, r := range regions {
+		hints = append(hints, entropyRegionHint{Start: r.Start, End: r.End, MeanEntropy: r.MeanEntropy})
+	}
+	reqBody := struct {
+		Path           string              `json:"path"`
+		EntropyRegions []entropyRegionHint `json:"entropy_regions,omitempty"`
+	}{Path: path, EntropyRegions: hints}
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
 		return nil, err
@@ -234,7 +349,37 @@ func loadScoringConfig(path string) (*ScoringConfig, error) {
 	return &config, nil
 }
 
-func processFile(path string, config *ScoringConfig, clamdAddr string, yaraHost string, yaraPort string, incomingDir string, quarantineDir string) *ScanResult {
+func processFile(path string, config *ScoringConfig, scanners []Scanner, scannerTimeout time.Duration, incomingDir string, quarantineDir string, cache *ScanCache, rescore bool) *ScanResult {
+	if isTarArchive(path) {
+		return processArchiveFile(path, config, scanners, scannerTimeout, quarantineDir)
+	}
+
+	// Hash before running any engine, so a file whose content already has a
+	// fresh cache entry (even one that reappeared under a different name)
+	// short-circuits without re-scanning.
+	sha, hashErr := sha256File(path)
+	versions := currentEngineVersions()
+	if hashErr != nil {
+		log.Printf("[%s] Warning: failed to hash file for cache lookup: %v", filepath.Base(path), hashErr)
+	} else if cache != nil {
+		if entry, ok := cache.get(sha, versions); ok {
+			result := &ScanResult{
+				Path:     path,
+				MimeType: entry.MimeType,
+				Signals:  entry.Signals,
+				Score:    entry.Score,
+				Decision: entry.Decision,
+			}
+			populateLegacyFields(result)
+			if rescore {
+				result.Score, result.Decision = scoreSignals(entry.Signals, config)
+			}
+			applyDecision(path, result, quarantineDir)
+			log.Printf("[%s] [cache-hit] MIME: %s, Score: %d, Decision: %s", filepath.Base(path), result.MimeType, result.Score, result.Decision)
+			return result
+		}
+	}
+
 	result := &ScanResult{Path: path}
 
 	// MIME detection
@@ -245,70 +390,128 @@ func processFile(path string, config *ScoringConfig, clamdAddr string, yaraHost
 	}
 	result.MimeType = mt.String()
 
-	// ClamAV scan (non-fatal - continue even if ClamAV is unavailable)
-	clamResult, err := scanClamAV(path, clamdAddr)
-	if err != nil {
-		// Log error but don't fail the scan - ClamAV might be unavailable in local dev
-		log.Printf("[%s] ClamAV warning: %v (scanning continues)", filepath.Base(path), err)
-		result.ClamAVResult = ""
-	} else {
-		result.ClamAVResult = clamResult
-	}
-
-	// YARA scan
-	yaraMatches, err := scanYARA(path, yaraHost, yaraPort)
-	if err != nil {
-		log.Printf("[%s] YARA error: %v", filepath.Base(path), err)
-	} else {
-		result.YaraMatches = yaraMatches
-	}
-
-	// Entropy calculation
+	// Entropy calculation. RawEntropy is always the entropy of the bytes on
+	// disk; DecompressedEntropy (when the file is a recognized compression
+	// wrapper) is the entropy of the underlying plaintext, which is what
+	// actually indicates encryption or nested compression.
 	f, err := os.Open(path)
 	if err == nil {
-		buf := make([]byte, 65536)
-		n, _ := f.Read(buf)
-		result.Entropy = entropy(buf[:n])
+		peek := make([]byte, 6)
+		n, _ := io.ReadFull(f, peek)
+		peek = peek[:n]
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			result.RawEntropy = sampleEntropy(f)
+			result.Entropy = result.RawEntropy
+
+			if format := detectCompression(peek); format != "" {
+				result.CompressionFormat = format
+				if _, err := f.Seek(0, io.SeekStart); err == nil {
+					if decompressed, err := decompressStream(format, f); err == nil {
+						result.DecompressedEntropy = sampleEntropy(decompressed)
+					} else {
+						log.Printf("[%s] Warning: failed to decompress for entropy sample: %v", filepath.Base(path), err)
+					}
+				}
+			}
+		}
+
+		// Sliding-window localization walks the whole stream (not just the
+		// 64KiB sample above) so an embedded encrypted payload inside a
+		// multi-GB backup shows up as a region instead of disappearing into
+		// the whole-file average.
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			var localizeBody io.Reader = f
+			if result.CompressionFormat != "" {
+				if decompressed, decErr := decompressStream(result.CompressionFormat, f); decErr == nil {
+					localizeBody = decompressed
+				} else {
+					localizeBody = nil
+				}
+			}
+			if localizeBody != nil {
+				points, lwErr := slidingWindowEntropy(localizeBody, entropyWindow, entropyStride)
+				if lwErr != nil {
+					log.Printf("[%s] Warning: entropy localization failed: %v", filepath.Base(path), lwErr)
+				} else {
+					result.EntropyRegions = detectEntropyRegions(points, entropyWindow, 6.5, entropyRegionMinRun, entropyRegionMergeGap)
+					for _, region := range result.EntropyRegions {
+						log.Printf("[%s] High-entropy region [%d-%d] (mean entropy: %.3f)", filepath.Base(path), region.Start, region.End, region.MeanEntropy)
+					}
+				}
+			}
+		}
+
 		if closeErr := f.Close(); closeErr != nil {
 			log.Printf("[%s] Warning: failed to close file: %v", filepath.Base(path), closeErr)
 		}
 	}
 
-	// Calculate score
-	result.Score = 0
-	if result.ClamAVResult != "" {
-		result.Score += config.Weights.ClamavInfected
-	}
-	if len(result.YaraMatches) > 0 {
-		result.Score += config.Weights.YaraMatch * len(result.YaraMatches)
+	// Run every registered scanner concurrently. Each is bounded by its own
+	// timeout, so a slow or crashing engine shows up as a per-scanner
+	// warning instead of stalling the worker pool or the rest of the file's
+	// scan.
+	meta := FileMeta{
+		Path:                path,
+		MimeType:            result.MimeType,
+		CompressionFormat:   result.CompressionFormat,
+		RawEntropy:          result.RawEntropy,
+		DecompressedEntropy: result.DecompressedEntropy,
+		EntropyRegions:      result.EntropyRegions,
+	}
+	signals, scanStats := runScanners(context.Background(), scanners, meta, scannerTimeout)
+	result.Signals = signals
+	result.ScannerStats = scanStats
+	populateLegacyFields(result)
+	for _, stat := range scanStats {
+		if stat.Err != nil {
+			log.Printf("[%s] %s scanner warning: %v (%dms, scanning continues)", filepath.Base(path), stat.Name, stat.Err, stat.DurationMs)
+		}
 	}
-	if result.Entropy > 6.5 {
-		result.Score += config.Weights.HighEntropy
+
+	// Calculate score and make a decision. scoreSignals is shared with the
+	// cache-hit/--rescore path so threshold tuning behaves identically
+	// whether or not the engines actually ran.
+	result.Score, result.Decision = scoreSignals(signals, config)
+	applyDecision(path, result, quarantineDir)
+
+	if hashErr == nil && cache != nil {
+		if err := cache.put(CacheEntry{
+			SHA256:    sha,
+			MimeType:  result.MimeType,
+			Signals:   signals,
+			Score:     result.Score,
+			Decision:  result.Decision,
+			ScannedAt: time.Now(),
+			Versions:  versions,
+		}); err != nil {
+			log.Printf("[%s] Warning: failed to persist scan cache entry: %v", filepath.Base(path), err)
+		}
 	}
 
-	// Make decision
-	if result.Score >= config.Thresholds.Quarantine {
-		result.Decision = "quarantine"
+	// Log details
+	log.Printf("[%s] MIME: %s, ClamAV: %s, YARA: %d matches, RawEntropy: %.3f, DecompressedEntropy: %.3f, Compression: %s, EntropyRegions: %d, Score: %d, Decision: %s",
+		filepath.Base(path), result.MimeType, result.ClamAVResult, len(result.YaraMatches),
+		result.RawEntropy, result.DecompressedEntropy, result.CompressionFormat, len(result.EntropyRegions), result.Score, result.Decision)
+
+	return result
+}
+
+// applyDecision acts on result.Decision: quarantining the file, or just
+// logging, for both freshly-scanned and cache-hit results.
+func applyDecision(path string, result *ScanResult, quarantineDir string) {
+	switch result.Decision {
+	case "quarantine":
 		dst := filepath.Join(quarantineDir, filepath.Base(path))
 		if err := os.Rename(path, dst); err != nil {
 			result.Error = fmt.Errorf("failed to quarantine: %w", err)
 		} else {
 			log.Printf("[%s] Quarantined (score: %d) -> %s", filepath.Base(path), result.Score, dst)
 		}
-	} else if result.Score >= config.Thresholds.ManualReview {
-		result.Decision = "manual_review"
+	case "manual_review":
 		log.Printf("[%s] Manual review required (score: %d)", filepath.Base(path), result.Score)
-	} else {
-		result.Decision = "auto_approve"
+	default:
 		log.Printf("[%s] Auto-approved (score: %d)", filepath.Base(path), result.Score)
 	}
-
-	// Log details
-	log.Printf("[%s] MIME: %s, ClamAV: %s, YARA: %d matches, Entropy: %.3f, Score: %d, Decision: %s",
-		filepath.Base(path), result.MimeType, result.ClamAVResult, len(result.YaraMatches),
-		result.Entropy, result.Score, result.Decision)
-
-	return result
 }
 
 func findFiles(dir string) ([]string, error) {
@@ -326,17 +529,24 @@ func findFiles(dir string) ([]string, error) {
 }
 
 func worker(id int, jobs <-chan string, results chan<- *ScanResult, wg *sync.WaitGroup,
-	config *ScoringConfig, clamdAddr string, yaraHost string, yaraPort string,
-	incomingDir string, quarantineDir string) {
+	config *ScoringConfig, scanners []Scanner, scannerTimeout time.Duration,
+	incomingDir string, quarantineDir string, cache *ScanCache, rescore bool) {
 	defer wg.Done()
 	for path := range jobs {
 		log.Printf("[Worker %d] Processing: %s", id, filepath.Base(path))
-		result := processFile(path, config, clamdAddr, yaraHost, yaraPort, incomingDir, quarantineDir)
+		result := processFile(path, config, scanners, scannerTimeout, incomingDir, quarantineDir, cache, rescore)
 		results <- result
 	}
 }
 
 func main() {
+	// --rescore replays scoreSignals against the current ScoringConfig for
+	// every cache hit instead of trusting the decision stored at scan time,
+	// so threshold tuning can be applied to historical data without
+	// re-scanning terabytes of backups.
+	rescore := flag.Bool("rescore", false, "re-run scoring against cached signals instead of trusting cached decisions")
+	flag.Parse()
+
 	// Configuration from environment
 	incomingDir := os.Getenv("INCOMING_DIR")
 	if incomingDir == "" {
@@ -383,6 +593,10 @@ func main() {
 	if w := os.Getenv("NUM_WORKERS"); w != "" {
 		fmt.Sscanf(w, "%d", &numWorkers)
 	}
+	cachePath := os.Getenv("SCAN_CACHE_PATH")
+	if cachePath == "" {
+		cachePath = "./scan-cache.json"
+	}
 
 	// Load scoring configuration
 	config, err := loadScoringConfig(configPath)
@@ -390,13 +604,35 @@ func main() {
 		log.Fatalf("Failed to load scoring config: %v", err)
 	}
 
+	cache, err := loadScanCache(cachePath)
+	if err != nil {
+		log.Fatalf("Failed to load scan cache: %v", err)
+	}
+
 	// Ensure quarantine directory exists
 	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
 		log.Fatalf("Failed to create quarantine directory: %v", err)
 	}
 
+	scannerTimeout := 30 * time.Second
+	if config.ScannerTimeoutSeconds > 0 {
+		scannerTimeout = time.Duration(config.ScannerTimeoutSeconds) * time.Second
+	}
+	ssdeepThreshold := config.SsdeepThreshold
+	if ssdeepThreshold == 0 {
+		ssdeepThreshold = 70
+	}
+	scanners := []Scanner{
+		&clamAVScanner{addr: clamdAddr},
+		&yaraEngineScanner{host: yaraHost, port: yaraPort},
+		&entropyScanner{threshold: 6.5},
+		newSsdeepScanner(config.SsdeepBlocklist, ssdeepThreshold),
+	}
+
 	log.Printf("Pipeline starting: incoming=%s, quarantine=%s, workers=%d", incomingDir, quarantineDir, numWorkers)
 	log.Printf("ClamAV: %s, YARA: %s:%s", clamdAddr, yaraHost, yaraPort)
+	log.Printf("Scan cache: %s, rescore=%v", cachePath, *rescore)
+	log.Printf("Scanners: %d registered, timeout=%s", len(scanners), scannerTimeout)
 
 	// Create channels for worker pool
 	jobs := make(chan string, 100)
@@ -406,7 +642,7 @@ func main() {
 	var wg sync.WaitGroup
 	for i := 1; i <= numWorkers; i++ {
 		wg.Add(1)
-		go worker(i, jobs, results, &wg, config, clamdAddr, yaraHost, yaraPort, incomingDir, quarantineDir)
+		go worker(i, jobs, results, &wg, config, scanners, scannerTimeout, incomingDir, quarantineDir, cache, *rescore)
 	}
 
 	// Process results in background
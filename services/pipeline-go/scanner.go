@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// FileMeta is the read-only context handed to every registered Scanner:
+// precomputed MIME type, compression and entropy signals a scanner can use
+// without having to re-read or re-derive them itself.
+type FileMeta struct {
+	Path                string
+	MimeType            string
+	CompressionFormat   string
+	RawEntropy          float64
+	DecompressedEntropy float64
+	EntropyRegions      []EntropyRegion
+}
+
+// Signals is the set of scoring inputs a Scanner contributes, keyed by a
+// dotted "<engine>.<signal>" name (e.g. "clamav.infected",
+// "yara.match_count") so ScoringConfig.Weights can assign a weight to any
+// signal any engine produces without either side knowing about the other.
+type Signals map[string]any
+
+// Scanner is a pluggable scan engine. Adding a new one (capa, a Sigma-style
+// rule engine, a remote VirusTotal-like API) means implementing this
+// interface and registering it in main() plus giving its signal keys a
+// weight in ScoringConfig's weights map — processFile itself never changes.
+type Scanner interface {
+	Name() string
+	Scan(ctx context.Context, meta FileMeta) (Signals, error)
+}
+
+// ScannerResult records one scanner's outcome for a single file: how long
+// it took and whether it errored, so a slow or crashing engine is visible in
+// logs instead of silently degrading the score.
+type ScannerResult struct {
+	Name       string
+	DurationMs int64
+	Err        error
+}
+
+// runScanners runs every scanner concurrently against meta under an
+// errgroup, each bounded by its own timeout so one slow or hanging engine
+// can't stall the others or the worker pool. A scanner's error is recorded
+// in the returned []ScannerResult rather than aborting the group — a
+// crashing engine shouldn't prevent the rest from scoring the file.
+func runScanners(ctx context.Context, scanners []Scanner, meta FileMeta, timeout time.Duration) (Signals, []ScannerResult) {
+	merged := Signals{}
+	var mu sync.Mutex
+	stats := make([]ScannerResult, len(scanners))
+
+	g, gctx := errgroup.WithContext(ctx)
+	for i, s := range scanners {
+		i, s := i, s
+		g.Go(func() error {
+			scanCtx, cancel := context.WithTimeout(gctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			signals, err := s.Scan(scanCtx, meta)
+			stats[i] = ScannerResult{Name: s.Name(), DurationMs: time.Since(start).Milliseconds(), Err: err}
+			if err != nil {
+				return nil
+			}
+
+			mu.Lock()
+			for k, v := range signals {
+				merged[k] = v
+			}
+			mu.Unlock()
+			return nil
+		})
+	}
+	g.Wait()
+
+	return merged, stats
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/glaslos/ssdeep"
+)
+
+// clamAVScanner wraps the existing ClamAV INSTREAM client as a Scanner.
+type clamAVScanner struct {
+	addr string
+}
+
+func (c *clamAVScanner) Name() string { return "clamav" }
+
+func (c *clamAVScanner) Scan(ctx context.Context, meta FileMeta) (Signals, error) {
+	signature, err := scanClamAV(meta.Path, c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return Signals{
+		"clamav.infected":  signature != "",
+		"clamav.signature": signature,
+	}, nil
+}
+
+// yaraEngineScanner wraps the existing YARA HTTP client as a Scanner. (Named
+// with an "Engine" infix so it doesn't collide with the scanYARA function.)
+type yaraEngineScanner struct {
+	host string
+	port string
+}
+
+func (y *yaraEngineScanner) Name() string { return "yara" }
+
+func (y *yaraEngineScanner) Scan(ctx context.Context, meta FileMeta) (Signals, error) {
+	matches, err := scanYARA(meta.Path, y.host, y.port, meta.EntropyRegions)
+	if err != nil {
+		return nil, err
+	}
+	return Signals{
+		"yara.match_count": len(matches),
+		"yara.matches":     matches,
+	}, nil
+}
+
+// entropyScanner doesn't read the file itself: RawEntropy, DecompressedEntropy
+// and EntropyRegions are already computed by processFile's sampling and
+// localization passes, so this just packages them as scoring signals the same way every
+// other engine's findings are packaged.
+type entropyScanner struct {
+	threshold float64
+}
+
+func (e *entropyScanner) Name() string { return "entropy" }
+
+func (e *entropyScanner) Scan(ctx context.Context, meta FileMeta) (Signals, error) {
+	scored := meta.RawEntropy
+	if meta.CompressionFormat != "" {
+		scored = meta.DecompressedEntropy
+	}
+	return Signals{
+		"entropy.high":         scored > e.threshold,
+		"entropy.value":        scored,
+		"entropy.region_count": len(meta.EntropyRegions),
+	}, nil
+}
+
+// ssdeepScanner flags files whose CTPH (context-triggered piecewise hash)
+// digest is a close fuzzy match for a known-bad sample, catching
+// near-duplicates of known pgBackRest tampering payloads that a byte-exact
+// ClamAV signature would miss.
+type ssdeepScanner struct {
+	blocklist []string
+	threshold int
+}
+
+func newSsdeepScanner(blocklist []string, threshold int) *ssdeepScanner {
+	return &ssdeepScanner{blocklist: blocklist, threshold: threshold}
+}
+
+func (s *ssdeepScanner) Name() string { return "ssdeep" }
+
+func (s *ssdeepScanner) Scan(ctx context.Context, meta FileMeta) (Signals, error) {
+	if len(s.blocklist) == 0 {
+		return Signals{"ssdeep.blocklist_match": false}, nil
+	}
+
+	f, err := os.Open(meta.Path)
+	if err != nil {
+		return nil, fmt.Errorf("open file for ssdeep: %w", err)
+	}
+	defer f.Close()
+
+	digest, err := ssdeep.FuzzyFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("compute ssdeep digest: %w", err)
+	}
+
+	best := 0
+	for _, known := range s.blocklist {
+		score, err := ssdeep.Distance(digest, known)
+		if err != nil {
+			continue
+		}
+		if score > best {
+			best = score
+		}
+	}
+
+	return Signals{
+		"ssdeep.digest":           digest,
+		"ssdeep.best_match_score": best,
+		"ssdeep.blocklist_match":  best >= s.threshold,
+	}, nil
+}
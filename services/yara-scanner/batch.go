@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BatchScanRequest is the JSON body accepted by POST /scan-batch when the
+// caller already has files on disk, as an alternative to a multipart upload.
+type BatchScanRequest struct {
+	Paths []string `json:"paths"`
+}
+
+// MultiScanResponse is the body of POST /scan-batch: a map of filename (or
+// path) to its matches, plus a parallel map of per-file errors so one bad
+// file in a batch doesn't abort the rest -- the map-of-filename-to-matches
+// shape a bulk YARA scanner reports results in.
+type MultiScanResponse struct {
+	Success bool               `json:"success"`
+	Files   map[string][]Match `json:"files"`
+	Errors  map[string]string  `json:"errors,omitempty"`
+}
+
+// batchItem pairs one submitted file's display name with either a path
+// already on disk or an uploaded file's reader.
+type batchItem struct {
+	name   string
+	path   string
+	reader io.ReadCloser
+	size   int64
+}
+
+// submitJobCtx behaves like submitJob, except the wait for a result can be
+// cut short by ctx -- so a fail_fast batch can stop waiting on still-queued
+// jobs once the first error trips cancellation. A job already running on a
+// worker keeps running to completion; its result is just discarded.
+func submitJobCtx(ctx context.Context, job Job) (JobResult, error) {
+	select {
+	case jobChan <- job:
+	default:
+		if job.Reader != nil {
+			job.Reader.Close()
+		}
+		return JobResult{}, fmt.Errorf("scan queue is full")
+	}
+	atomic.AddUint64(&jobsQueued, 1)
+
+	select {
+	case result := <-job.ResultChan:
+		if result.Err != nil {
+			return result, result.Err
+		}
+		return result, nil
+	case <-ctx.Done():
+		return JobResult{}, ctx.Err()
+	}
+}
+
+// scanBatchHandler scans many files in one request, either a multipart
+// upload with several "file" fields or a JSON {"paths":[...]} body. Each
+// file is dispatched into the worker pool independently and awaited with a
+// sync.WaitGroup, so one bad file only shows up under its own name in the
+// errors map instead of failing the whole batch. ?fail_fast=true cancels
+// the shared context on the first error, so jobs still waiting in the queue
+// stop being waited on instead of running to completion for nothing.
+func scanBatchHandler(c *gin.Context) {
+	pack := c.Query("pack")
+
+	var items []batchItem
+	if c.ContentType() == "multipart/form-data" {
+		form, err := c.MultipartForm()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid multipart form"})
+			return
+		}
+		files := form.File["file"]
+		if len(files) == 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "no files provided"})
+			return
+		}
+		for _, fh := range files {
+			src, err := fh.Open()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{
+					Error: fmt.Sprintf("failed to open %s: %v", fh.Filename, err),
+				})
+				return
+			}
+			items = append(items, batchItem{name: fh.Filename, reader: src, size: fh.Size})
+		}
+	} else {
+		var req BatchScanRequest
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Paths) == 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: `no files provided: submit multipart "file" fields or a JSON {"paths":[...]} body`,
+			})
+			return
+		}
+		for _, p := range req.Paths {
+			items = append(items, batchItem{name: p, path: p, size: -1})
+		}
+	}
+
+	if _, ok := getPack(pack); !ok {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: fmt.Sprintf("No YARA rules loaded for pack %q", packNameOrDefault(pack)),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var cancel context.CancelFunc
+	if c.Query("fail_fast") == "true" {
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+	}
+	details := detailsFromQuery(c)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[string][]Match, len(items))
+		errs    = make(map[string]string)
+	)
+
+	for _, item := range items {
+		wg.Add(1)
+		go func(item batchItem) {
+			defer wg.Done()
+
+			// A path-based item already gets its filename from item.path via
+			// runScanJob's filepath.Base fallback; a multipart item has no
+			// path, so its real name has to be passed through explicitly or
+			// filename/extension-conditioned rules never see it.
+			filename := ""
+			if item.reader != nil {
+				filename = item.name
+			}
+
+			result, err := submitJobCtx(ctx, Job{
+				Path:       item.path,
+				Reader:     item.reader,
+				Pack:       pack,
+				Filename:   filename,
+				Size:       item.size,
+				Ctx:        ctx,
+				Details:    details,
+				ResultChan: make(chan JobResult, 1),
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[item.name] = err.Error()
+				if cancel != nil {
+					cancel()
+				}
+				return
+			}
+			results[item.name] = result.Matches
+		}(item)
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, MultiScanResponse{
+		Success: len(errs) == 0,
+		Files:   results,
+		Errors:  errs,
+	})
+}
@@ -1,39 +1,390 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hillu/go-yara/v4"
 )
 
+// maxMatchBytes bounds how many bytes of a matched string's raw data get
+// base64-encoded into a response, so a rule that matches a multi-megabyte
+// blob doesn't blow up the response body. Set from YARA_MAX_MATCH_BYTES at
+// startup.
+var maxMatchBytes = 256
+
+// maxScanBytes bounds how much of an upload runScanJob will hold in memory
+// for a ScanMem pass before spilling to a temp file instead. Set from
+// YARA_MAX_SCAN_BYTES (bytes) at startup; default 256 MiB.
+var maxScanBytes = 256 * 1024 * 1024
+
+// scanTimeout is the default per-scan libyara timeout, overridden by a
+// shorter deadline already carried on the request context. Set from
+// YARA_SCAN_TIMEOUT (seconds) at startup.
+var scanTimeout = 60 * time.Second
+
+// copyBufPool reuses the buffers runScanJob streams an oversized upload to
+// disk with, so a burst of large uploads doesn't allocate a fresh 32KiB
+// buffer per request.
+var copyBufPool = sync.Pool{
+	New: func() any { return make([]byte, 32*1024) },
+}
+
+var rulesMutex sync.RWMutex
+
+// Job is one unit of scan work submitted by an HTTP handler. Exactly one of
+// Path or Reader is set: scanFileHandler already has a path on disk,
+// scanHandler has an uploaded file's bytes that the worker stages itself (in
+// memory, or in a temp file when oversized) so handlers never touch the
+// filesystem directly.
+type Job struct {
+	Path   string
+	Reader io.ReadCloser
+	Pack   string
+	// Filename and Filetype override the filename/filetype external
+	// variables a scan exposes to rules. Left empty, they're derived from
+	// Path and a content-type sniff; set explicitly for an uploaded temp
+	// file whose real name and type would otherwise be lost.
+	Filename string
+	Filetype string
+	// Size is the upload's known length (-1 if unknown, e.g. a chunked
+	// request), used to decide whether it fits under maxScanBytes for an
+	// in-memory ScanMem pass.
+	Size int64
+	// Ctx is the originating request's context. Its deadline (if any)
+	// tightens the libyara scan timeout below scanTimeout. A job whose Ctx
+	// is already done when a worker picks it up is not scanned; it carries
+	// no cancellation signal into an already-running scan.
+	Ctx context.Context
+	// Details controls whether the result includes per-match string/offset
+	// detail or just the lightweight rule/tag hit list (?details=false).
+	Details bool
+	// EntropyRegions are the caller's high-entropy byte-range hints, logged
+	// by runScanJob so an analyst can dd the suspicious slice out by hand.
+	EntropyRegions []EntropyRegionHint
+	ResultChan     chan JobResult
+}
+
+// JobResult is handed back to the submitting handler over Job.ResultChan.
+type JobResult struct {
+	Matches []Match
+	Err     error
+}
+
 var (
-	compiledRules *yara.Rules
-	rulesDir      string
-	rulesMutex    sync.RWMutex
+	jobChan chan Job
+
+	jobsProcessed   uint64
+	jobsQueued      uint64
+	jobsFailed      uint64
+	matchesDetected uint64
+	ruleHits        sync.Map // map[string]*uint64
 )
 
+// startWorkerPool launches the fixed pool of goroutines that drain jobChan,
+// so concurrent uploads no longer scan on the request goroutine and exhaust
+// CPU/memory under load. workers and queueDepth are configurable via
+// YARA_WORKERS/YARA_QUEUE_DEPTH so operators can size the pool to the host.
+func startWorkerPool(workers, queueDepth int) {
+	jobChan = make(chan Job, queueDepth)
+	for i := 0; i < workers; i++ {
+		go func(id int) {
+			for job := range jobChan {
+				job.ResultChan <- runScanJob(job)
+			}
+		}(i)
+	}
+	log.Printf("Worker pool started: workers=%d queue_depth=%d", workers, queueDepth)
+}
+
+// submitJob enqueues job and blocks for its result. It returns ok=false
+// without blocking when the queue is full, so the caller can respond 503
+// instead of piling up unbounded work behind a saturated pool.
+func submitJob(job Job) (JobResult, bool) {
+	select {
+	case jobChan <- job:
+	default:
+		return JobResult{}, false
+	}
+	atomic.AddUint64(&jobsQueued, 1)
+	return <-job.ResultChan, true
+}
+
+// runScanJob performs the actual YARA scan for one job, on a worker
+// goroutine. An uploaded Reader under maxScanBytes is scanned straight out
+// of memory with ScanMem; anything larger is streamed to a temp file instead
+// since yara.Rules.ScanFile needs a path (an upload of unknown size is
+// buffered first and spilled only once it passes maxScanBytes).
+func runScanJob(job Job) JobResult {
+	if job.Ctx != nil && job.Ctx.Err() != nil {
+		if job.Reader != nil {
+			job.Reader.Close()
+		}
+		return JobResult{Err: job.Ctx.Err()}
+	}
+
+	path := job.Path
+	var mem []byte
+	if job.Reader != nil {
+		defer job.Reader.Close()
+
+		data, tmpPath, err := stageUpload(job.Reader, job.Size)
+		if err != nil {
+			atomic.AddUint64(&jobsFailed, 1)
+			return JobResult{Err: err}
+		}
+		if tmpPath != "" {
+			defer os.Remove(tmpPath)
+			path = tmpPath
+		} else {
+			mem = data
+		}
+	}
+
+	pack, ok := getPack(job.Pack)
+	if !ok {
+		atomic.AddUint64(&jobsFailed, 1)
+		return JobResult{Err: fmt.Errorf("no YARA rules loaded for pack %q", packNameOrDefault(job.Pack))}
+	}
+
+	filename := job.Filename
+	if filename == "" && path != "" {
+		filename = filepath.Base(path)
+	}
+	absPath := path
+	if path != "" {
+		if a, err := filepath.Abs(path); err == nil {
+			absPath = a
+		}
+	}
+	filetype := job.Filetype
+	if filetype == "" {
+		if mem != nil {
+			filetype = sniffFileType(mem)
+		} else {
+			filetype = detectFileType(path)
+		}
+	}
+
+	for _, reg := range job.EntropyRegions {
+		log.Printf("%s: high-entropy region hint [%d,%d) mean_entropy=%.3f -- dd if=%s bs=1 skip=%d count=%d",
+			filename, reg.Start, reg.End, reg.MeanEntropy, path, reg.Start, reg.End-reg.Start)
+	}
+
+	// pack.scanMu serializes the Define+Scan sequence: libyara's external
+	// variables live on the compiled Rules object, not per-scan, so two
+	// goroutines scanning this same pack concurrently with different file
+	// metadata would otherwise race.
+	pack.scanMu.Lock()
+	defer pack.scanMu.Unlock()
+	if err := setScanVariables(pack.Rules, filename, absPath, filepath.Ext(filename), filetype); err != nil {
+		atomic.AddUint64(&jobsFailed, 1)
+		return JobResult{Err: fmt.Errorf("set scan variables: %w", err)}
+	}
+
+	collector := &matchCollector{includeStrings: job.Details, maxMatchBytes: maxMatchBytes}
+	timeout := effectiveScanTimeout(job.Ctx)
+	var scanErr error
+	if mem != nil {
+		scanErr = pack.Rules.ScanMem(mem, 0, timeout, collector)
+	} else {
+		scanErr = pack.Rules.ScanFile(path, 0, timeout, collector)
+	}
+	if scanErr != nil {
+		atomic.AddUint64(&jobsFailed, 1)
+		return JobResult{Err: fmt.Errorf("YARA scan failed: %w", scanErr)}
+	}
+
+	for _, m := range collector.matches {
+		recordRuleHit(pack.Name, m.Rule)
+	}
+
+	atomic.AddUint64(&jobsProcessed, 1)
+	atomic.AddUint64(&matchesDetected, uint64(len(collector.matches)))
+	return JobResult{Matches: collector.matches}
+}
+
+// stageUpload reads r into memory for a ScanMem pass when it fits under
+// maxScanBytes, and otherwise streams it to a temp file for ScanFile. sizeHint
+// is the upload's Content-Length if known, -1 otherwise (e.g. chunked
+// transfer-encoding); a negative hint means we only find out it overflowed
+// the limit after having already buffered maxScanBytes+1 bytes, in which case
+// the buffered prefix and the rest of the stream are both spilled to disk
+// rather than scanning a truncated copy or rejecting outright.
+func stageUpload(r io.Reader, sizeHint int64) (mem []byte, tmpPath string, err error) {
+	if sizeHint >= 0 && sizeHint > int64(maxScanBytes) {
+		tmpPath, err = spillToTemp(r)
+		return nil, tmpPath, err
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(r, int64(maxScanBytes)+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read upload: %w", err)
+	}
+	if len(buf) <= maxScanBytes {
+		return buf, "", nil
+	}
+
+	tmpPath, err = spillToTemp(io.MultiReader(bytes.NewReader(buf), r))
+	return nil, tmpPath, err
+}
+
+// spillToTemp drains r to a fresh temp file using a pooled copy buffer, so a
+// burst of oversized uploads doesn't allocate a new buffer per request.
+func spillToTemp(r io.Reader) (string, error) {
+	tmpFile, err := os.CreateTemp("", "yara-scan-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer tmpFile.Close()
+
+	buf := copyBufPool.Get().([]byte)
+	defer copyBufPool.Put(buf)
+
+	if _, err := io.CopyBuffer(tmpFile, r, buf); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to save uploaded file: %w", err)
+	}
+	return tmpFile.Name(), nil
+}
+
+// effectiveScanTimeout returns scanTimeout, tightened to whatever's left on
+// ctx's deadline when that's sooner, so a scan can't outlive a request that
+// already has a stricter deadline imposed upstream.
+func effectiveScanTimeout(ctx context.Context) time.Duration {
+	timeout := scanTimeout
+	if ctx == nil {
+		return timeout
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining > 0 && remaining < timeout {
+			timeout = remaining
+		}
+	}
+	return timeout
+}
+
+// setScanVariables updates the filename/filepath/extension/filetype external
+// variables a scan exposes to rules that reference them, matching the four
+// identifiers externalVariableDefaults declares at compile time.
+func setScanVariables(rules *yara.Rules, filename, path, extension, filetype string) error {
+	vars := map[string]string{
+		"filename":  filename,
+		"filepath":  path,
+		"extension": extension,
+		"filetype":  filetype,
+	}
+	for name, value := range vars {
+		if err := rules.DefineVariable(name, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// detectFileType sniffs the first 512 bytes of path with
+// net/http.DetectContentType, so rules that key off a "filetype" external
+// variable see a real MIME guess instead of an empty string when the
+// caller doesn't supply one explicitly.
+func detectFileType(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, _ := f.Read(buf)
+	return http.DetectContentType(buf[:n])
+}
+
+// sniffFileType is detectFileType's in-memory counterpart, used when a scan
+// ran against a buffered upload that never touched disk.
+func sniffFileType(data []byte) string {
+	n := len(data)
+	if n > 512 {
+		n = 512
+	}
+	return http.DetectContentType(data[:n])
+}
+
+// recordRuleHit keys hit counts by "<pack>:<rule>" rather than bare rule
+// name, since two packs are free to both define a rule called e.g. "eicar".
+func recordRuleHit(pack, rule string) {
+	key := pack + ":" + rule
+	v, _ := ruleHits.LoadOrStore(key, new(uint64))
+	atomic.AddUint64(v.(*uint64), 1)
+}
+
+func envIntOrDefault(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return n
+}
+
 type HealthResponse struct {
-	Status      string `json:"status"`
-	RulesLoaded bool   `json:"rules_loaded"`
-	RulesDir    string `json:"rules_dir"`
+	Status      string   `json:"status"`
+	RulesLoaded bool     `json:"rules_loaded"`
+	Packs       []string `json:"packs"`
 }
 
 type ScanRequest struct {
 	Path string `json:"path"`
+	Pack string `json:"pack,omitempty"`
+	// EntropyRegions are byte ranges the pipeline's own localization pass
+	// flagged as anomalously dense, passed along as a scan hint. libyara has
+	// no API to scan only a sub-range of a file, so for now these are just
+	// logged for an analyst to pull out with dd rather than narrowing the
+	// scan itself.
+	EntropyRegions []EntropyRegionHint `json:"entropy_regions,omitempty"`
+}
+
+// EntropyRegionHint mirrors the pipeline's entropyRegionHint wire shape.
+type EntropyRegionHint struct {
+	Start       int64   `json:"start"`
+	End         int64   `json:"end"`
+	MeanEntropy float64 `json:"mean_entropy"`
 }
 
+// Match is one rule hit reported back to a caller. Strings is omitted
+// entirely when the scan was run with details=false.
 type Match struct {
-	Rule    string   `json:"rule"`
-	Tags    []string `json:"tags"`
-	Strings []string `json:"strings,omitempty"`
+	Rule      string                 `json:"rule"`
+	Namespace string                 `json:"namespace"`
+	Tags      []string               `json:"tags"`
+	Meta      map[string]interface{} `json:"meta,omitempty"`
+	Strings   []MatchString          `json:"strings,omitempty"`
+}
+
+// MatchString is one matched string instance within a rule hit: its rule
+// identifier (e.g. "$a"), the byte offset it matched at, and a
+// base64-encoded snippet of the matched bytes bounded to maxMatchBytes so a
+// hit against a huge blob doesn't balloon the response.
+type MatchString struct {
+	Name    string `json:"name"`
+	Offset  int64  `json:"offset"`
+	Length  int    `json:"length"`
+	DataB64 string `json:"data_b64"`
 }
 
 type ScanResponse struct {
@@ -45,96 +396,54 @@ type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
-// matchCollector implements yara.ScanCallback to collect matches
+// matchCollector implements yara.ScanCallback, buffering every rule hit for
+// one scan. Unless includeStrings is false (details=false), it also records
+// each matched string's offset and a bounded, base64-encoded snippet of the
+// matched bytes.
 type matchCollector struct {
-	matches []yara.MatchRule
+	matches        []Match
+	includeStrings bool
+	maxMatchBytes  int
 }
 
-func (mc *matchCollector) RuleMatching(ctx *yara.ScanContext, rule *yara.Rule) (bool, error) {
-	// Get rule strings (these are the pattern strings, not the matched values)
-	ruleStrings := rule.Strings()
-	matchStrings := make([]yara.MatchString, 0, len(ruleStrings))
-
-	// For now, we'll just record the rule identifier and tags
-	// MatchString details would require more complex extraction from ScanContext
-	mc.matches = append(mc.matches, yara.MatchRule{
+func (mc *matchCollector) RuleMatching(sc *yara.ScanContext, rule *yara.Rule) (bool, error) {
+	match := Match{
 		Rule:      rule.Identifier(),
 		Namespace: rule.Namespace(),
 		Tags:      rule.Tags(),
-		Strings:   matchStrings,
-	})
-	return true, nil
-}
-
-func loadRules() error {
-	rulesDir = os.Getenv("YARA_RULES_DIR")
-	if rulesDir == "" {
-		rulesDir = "/rules"
 	}
 
-	// Check if directory exists
-	if _, err := os.Stat(rulesDir); os.IsNotExist(err) {
-		log.Printf("Warning: Rules directory %s does not exist", rulesDir)
-		return fmt.Errorf("rules directory does not exist")
-	}
-
-	// Find all .yar and .yara files
-	var ruleFiles []string
-	err := filepath.Walk(rulesDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	if metas := rule.Metas(); len(metas) > 0 {
+		match.Meta = make(map[string]interface{}, len(metas))
+		for _, m := range metas {
+			match.Meta[m.Identifier] = m.Value
 		}
-		if !info.IsDir() {
-			ext := strings.ToLower(filepath.Ext(path))
-			if ext == ".yar" || ext == ".yara" {
-				ruleFiles = append(ruleFiles, path)
-			}
-		}
-		return nil
-	})
-
-	if err != nil {
-		return fmt.Errorf("error walking rules directory: %w", err)
-	}
-
-	if len(ruleFiles) == 0 {
-		log.Printf("Warning: No YARA rule files found in %s", rulesDir)
-		return fmt.Errorf("no YARA rule files found")
-	}
-
-	// Compile all rules
-	compiler, err := yara.NewCompiler()
-	if err != nil {
-		return fmt.Errorf("failed to create YARA compiler: %w", err)
 	}
 
-	for _, ruleFile := range ruleFiles {
-		f, err := os.Open(ruleFile)
-		if err != nil {
-			log.Printf("Warning: Failed to open rule file %s: %v", ruleFile, err)
-			continue
-		}
-
-		namespace := filepath.Base(ruleFile)
-		namespace = strings.TrimSuffix(namespace, filepath.Ext(namespace))
-		err = compiler.AddFile(f, namespace)
-		f.Close() // Close immediately after use, not deferred
-		if err != nil {
-			log.Printf("Warning: Failed to compile rule file %s: %v", ruleFile, err)
-			continue
+	if mc.includeStrings {
+		for _, s := range rule.Strings() {
+			for _, hit := range s.Matches(sc) {
+				data := hit.Data()
+				length := len(data)
+				if mc.maxMatchBytes > 0 && length > mc.maxMatchBytes {
+					data = data[:mc.maxMatchBytes]
+				}
+				match.Strings = append(match.Strings, MatchString{
+					Name:    s.Identifier(),
+					Offset:  hit.Offset(),
+					Length:  length,
+					DataB64: base64.StdEncoding.EncodeToString(data),
+				})
+			}
 		}
 	}
 
-	rules, err := compiler.GetRules()
-	if err != nil {
-		return fmt.Errorf("failed to get compiled rules: %w", err)
-	}
+	mc.matches = append(mc.matches, match)
+	return true, nil
+}
 
-	rulesMutex.Lock()
-	compiledRules = rules
-	rulesMutex.Unlock()
-	log.Printf("Loaded %d YARA rule files from %s", len(ruleFiles), rulesDir)
-	return nil
+type ReloadRequest struct {
+	Pack string `json:"pack,omitempty"`
 }
 
 type ReloadResponse struct {
@@ -143,20 +452,98 @@ type ReloadResponse struct {
 	RuleCount int    `json:"rule_count,omitempty"`
 }
 
-func healthHandler(c *gin.Context) {
-	rulesMutex.RLock()
-	loaded := compiledRules != nil
-	rulesMutex.RUnlock()
+// StatsResponse is the JSON body for GET /stats: a snapshot of the worker
+// pool's atomic counters plus per-rule hit counts, for operators who want
+// the numbers without scraping Prometheus text format.
+type StatsResponse struct {
+	JobsProcessed   uint64            `json:"jobs_processed"`
+	JobsQueued      uint64            `json:"jobs_queued"`
+	JobsFailed      uint64            `json:"jobs_failed"`
+	MatchesDetected uint64            `json:"matches_detected"`
+	RuleHits        map[string]uint64 `json:"rule_hits"`
+	QueueDepth      int               `json:"queue_depth"`
+	QueueCapacity   int               `json:"queue_capacity"`
+}
+
+func collectRuleHits() map[string]uint64 {
+	hits := make(map[string]uint64)
+	ruleHits.Range(func(k, v any) bool {
+		hits[k.(string)] = atomic.LoadUint64(v.(*uint64))
+		return true
+	})
+	return hits
+}
+
+func statsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, StatsResponse{
+		JobsProcessed:   atomic.LoadUint64(&jobsProcessed),
+		JobsQueued:      atomic.LoadUint64(&jobsQueued),
+		JobsFailed:      atomic.LoadUint64(&jobsFailed),
+		MatchesDetected: atomic.LoadUint64(&matchesDetected),
+		RuleHits:        collectRuleHits(),
+		QueueDepth:      len(jobChan),
+		QueueCapacity:   cap(jobChan),
+	})
+}
+
+// metricsHandler exposes the same counters as GET /stats in Prometheus text
+// exposition format, so the pool can be scraped alongside the rest of the
+// stack's metrics instead of only polled via JSON.
+func metricsHandler(c *gin.Context) {
+	var b strings.Builder
+
+	writeCounter := func(name, help string, value uint64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+
+	writeCounter("yara_scanner_jobs_processed_total", "Total scan jobs completed successfully.", atomic.LoadUint64(&jobsProcessed))
+	writeCounter("yara_scanner_jobs_queued_total", "Total scan jobs accepted onto the queue.", atomic.LoadUint64(&jobsQueued))
+	writeCounter("yara_scanner_jobs_failed_total", "Total scan jobs that errored.", atomic.LoadUint64(&jobsFailed))
+	writeCounter("yara_scanner_matches_detected_total", "Total YARA rule matches across all scans.", atomic.LoadUint64(&matchesDetected))
+
+	fmt.Fprintf(&b, "# HELP yara_scanner_queue_depth Current number of jobs waiting in the queue.\n# TYPE yara_scanner_queue_depth gauge\nyara_scanner_queue_depth %d\n", len(jobChan))
 
+	fmt.Fprintf(&b, "# HELP yara_scanner_rule_hits_total Total matches per rule identifier.\n# TYPE yara_scanner_rule_hits_total counter\n")
+	ruleHits.Range(func(k, v any) bool {
+		fmt.Fprintf(&b, "yara_scanner_rule_hits_total{rule=%q} %d\n", k.(string), atomic.LoadUint64(v.(*uint64)))
+		return true
+	})
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(b.String()))
+}
+
+func healthHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, HealthResponse{
 		Status:      "healthy",
-		RulesLoaded: loaded,
-		RulesDir:    rulesDir,
+		RulesLoaded: len(loadedPackNames()) > 0,
+		Packs:       loadedPackNames(),
 	})
 }
 
+// reloadRulesHandler reloads everything by default. A JSON body with a
+// "pack" field instead reloads just that one pack in place, leaving every
+// other pack's compiled rules untouched, so tuning one ruleset doesn't pay
+// for (or risk) recompiling the rest.
 func reloadRulesHandler(c *gin.Context) {
-	if err := loadRules(); err != nil {
+	var req ReloadRequest
+	_ = c.ShouldBindJSON(&req) // an empty/absent body means "reload everything"
+
+	if req.Pack != "" {
+		if err := reloadPack(req.Pack); err != nil {
+			c.JSON(http.StatusInternalServerError, ReloadResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to reload pack %q: %v", req.Pack, err),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, ReloadResponse{
+			Success: true,
+			Message: fmt.Sprintf("Pack %q reloaded successfully", req.Pack),
+		})
+		return
+	}
+
+	if err := loadAllPacks(); err != nil {
 		c.JSON(http.StatusInternalServerError, ReloadResponse{
 			Success: false,
 			Message: fmt.Sprintf("Failed to reload rules: %v", err),
@@ -164,29 +551,25 @@ func reloadRulesHandler(c *gin.Context) {
 		return
 	}
 
-	rulesMutex.RLock()
-	ruleCount := 0
-	if compiledRules != nil {
-		// Count rules by checking if ruleset is valid
-		ruleCount = 1 // Indicate rules are loaded
-	}
-	rulesMutex.RUnlock()
-
 	c.JSON(http.StatusOK, ReloadResponse{
 		Success:   true,
 		Message:   "Rules reloaded successfully",
-		RuleCount: ruleCount,
+		RuleCount: len(loadedPackNames()),
 	})
 }
 
-func scanHandler(c *gin.Context) {
-	rulesMutex.RLock()
-	rules := compiledRules
-	rulesMutex.RUnlock()
+// detailsFromQuery reports whether a scan should include per-match string
+// detail. It defaults to true; only an explicit ?details=false suppresses
+// it, for callers that just want the rule hit list.
+func detailsFromQuery(c *gin.Context) bool {
+	return c.Query("details") != "false"
+}
 
-	if rules == nil {
+func scanHandler(c *gin.Context) {
+	pack := c.Query("pack")
+	if _, ok := getPack(pack); !ok {
 		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			Error: "No YARA rules loaded",
+			Error: fmt.Sprintf("No YARA rules loaded for pack %q", packNameOrDefault(pack)),
 		})
 		return
 	}
@@ -206,17 +589,6 @@ func scanHandler(c *gin.Context) {
 		return
 	}
 
-	// Save uploaded file temporarily
-	tmpFile, err := os.CreateTemp("", "yara-scan-*")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: fmt.Sprintf("Failed to create temp file: %v", err),
-		})
-		return
-	}
-	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
-
 	src, err := file.Open()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -224,58 +596,51 @@ func scanHandler(c *gin.Context) {
 		})
 		return
 	}
-	defer src.Close()
 
-	_, err = io.Copy(tmpFile, src)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: fmt.Sprintf("Failed to save uploaded file: %v", err),
+	// The uploaded file is staged in memory or to an anonymous temp path
+	// before scanning, which loses its real name -- default to the multipart filename, but
+	// let the caller override filename/filetype explicitly since an
+	// integrator re-scanning its own temp file knows better than a sniff.
+	filename := c.Query("filename")
+	if filename == "" {
+		filename = file.Filename
+	}
+	filetype := c.Query("filetype")
+
+	// Hand the upload off to the worker pool instead of scanning on the
+	// request goroutine, so a burst of concurrent uploads queues instead of
+	// exhausting CPU/memory.
+	result, accepted := submitJob(Job{
+		Reader:     src,
+		Pack:       pack,
+		Filename:   filename,
+		Filetype:   filetype,
+		Size:       file.Size,
+		Ctx:        c.Request.Context(),
+		Details:    detailsFromQuery(c),
+		ResultChan: make(chan JobResult, 1),
+	})
+	if !accepted {
+		src.Close()
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: "Scan queue is full, try again shortly",
 		})
 		return
 	}
-	tmpFile.Close()
-
-	// Scan with YARA (rules already checked above)
-	collector := &matchCollector{matches: make([]yara.MatchRule, 0)}
-	err = rules.ScanFile(tmpFile.Name(), 0, 0, collector)
-	if err != nil {
+	if result.Err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: fmt.Sprintf("YARA scan failed: %v", err),
+			Error: result.Err.Error(),
 		})
 		return
 	}
 
-	// Format results
-	results := make([]Match, 0, len(collector.matches))
-	for _, m := range collector.matches {
-		match := Match{
-			Rule: m.Rule,
-			Tags: m.Tags,
-		}
-		for _, s := range m.Strings {
-			match.Strings = append(match.Strings, fmt.Sprintf("%s:%s", s.Name, s.Data))
-		}
-		results = append(results, match)
-	}
-
 	c.JSON(http.StatusOK, ScanResponse{
-		Matches:    results,
-		MatchCount: len(results),
+		Matches:    result.Matches,
+		MatchCount: len(result.Matches),
 	})
 }
 
 func scanFileHandler(c *gin.Context) {
-	rulesMutex.RLock()
-	rules := compiledRules
-	rulesMutex.RUnlock()
-
-	if rules == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			Error: "No YARA rules loaded",
-		})
-		return
-	}
-
 	var req ScanRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -284,6 +649,13 @@ func scanFileHandler(c *gin.Context) {
 		return
 	}
 
+	if _, ok := getPack(req.Pack); !ok {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: fmt.Sprintf("No YARA rules loaded for pack %q", packNameOrDefault(req.Pack)),
+		})
+		return
+	}
+
 	if _, err := os.Stat(req.Path); os.IsNotExist(err) {
 		c.JSON(http.StatusNotFound, ErrorResponse{
 			Error: "File not found",
@@ -291,39 +663,48 @@ func scanFileHandler(c *gin.Context) {
 		return
 	}
 
-	// Scan with YARA (rules already checked above)
-	collector := &matchCollector{matches: make([]yara.MatchRule, 0)}
-	err := rules.ScanFile(req.Path, 0, 0, collector)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: fmt.Sprintf("YARA scan failed: %v", err),
+	result, accepted := submitJob(Job{
+		Path:           req.Path,
+		Pack:           req.Pack,
+		Size:           -1,
+		Ctx:            c.Request.Context(),
+		Details:        detailsFromQuery(c),
+		EntropyRegions: req.EntropyRegions,
+		ResultChan:     make(chan JobResult, 1),
+	})
+	if !accepted {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: "Scan queue is full, try again shortly",
 		})
 		return
 	}
-
-	// Format results
-	results := make([]Match, 0, len(collector.matches))
-	for _, m := range collector.matches {
-		match := Match{
-			Rule: m.Rule,
-			Tags: m.Tags,
-		}
-		results = append(results, match)
+	if result.Err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: result.Err.Error(),
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, ScanResponse{
-		Matches:    results,
-		MatchCount: len(results),
+		Matches:    result.Matches,
+		MatchCount: len(result.Matches),
 	})
 }
 
 func main() {
-	// Load rules at startup
-	if err := loadRules(); err != nil {
-		log.Printf("Error loading YARA rules: %v", err)
+	// Load rule packs at startup
+	if err := loadAllPacks(); err != nil {
+		log.Printf("Error loading YARA rule packs: %v", err)
 		log.Println("Service may not function correctly")
 	}
 
+	workers := envIntOrDefault("YARA_WORKERS", runtime.NumCPU())
+	queueDepth := envIntOrDefault("YARA_QUEUE_DEPTH", 100)
+	maxMatchBytes = envIntOrDefault("YARA_MAX_MATCH_BYTES", maxMatchBytes)
+	maxScanBytes = envIntOrDefault("YARA_MAX_SCAN_BYTES", maxScanBytes)
+	scanTimeout = time.Duration(envIntOrDefault("YARA_SCAN_TIMEOUT", int(scanTimeout.Seconds()))) * time.Second
+	startWorkerPool(workers, queueDepth)
+
 	// Set Gin to release mode
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
@@ -332,7 +713,13 @@ func main() {
 	r.GET("/health", healthHandler)
 	r.POST("/scan", scanHandler)
 	r.POST("/scan-file", scanFileHandler)
+	r.POST("/scan-batch", scanBatchHandler)
 	r.POST("/reload", reloadRulesHandler)
+	r.GET("/stats", statsHandler)
+	r.GET("/metrics", metricsHandler)
+	r.GET("/packs", packsHandler)
+	r.GET("/rules/:pack", rulesPackHandler)
+	r.GET("/rules/:pack/:rule_id", ruleHandler)
 
 	log.Println("YARA Scanner service starting on :8081")
 	if err := r.Run(":8081"); err != nil {
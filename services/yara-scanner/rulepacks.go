@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hillu/go-yara/v4"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultPackName = "default"
+
+// PackSource is one entry in a pack's source list: a local directory, a
+// single .yar/.yara file, an HTTP(S) URL, or a package-url-style git
+// reference ("pkg:github/<owner>/<repo>[@<ref>]#<subdir>"), mirroring how a
+// signature-base-style rule server points at where its rules live.
+type PackSource = string
+
+// PackConfig is one named pack in the YARA_RULES_CONFIG file.
+type PackConfig struct {
+	Name    string       `json:"name" yaml:"name"`
+	Sources []PackSource `json:"sources" yaml:"sources"`
+}
+
+// rulesConfigFile is the top-level shape of YARA_RULES_CONFIG.
+type rulesConfigFile struct {
+	Packs []PackConfig `json:"packs" yaml:"packs"`
+}
+
+// Pack is one compiled, independently-reloadable set of YARA rules.
+type Pack struct {
+	Name    string
+	Rules   *yara.Rules
+	Sources []PackSource
+	// RuleSource holds each compiled rule file's raw YARA text, keyed by
+	// namespace (the file's basename minus extension) -- the same identifier
+	// the original single-pack loadRules used, so GET /rules/{pack}/{rule_id}
+	// can serve a file back without the compiler exposing a per-rule-block
+	// source locator.
+	RuleSource map[string]string
+	// scanMu serializes DefineVariable+ScanFile against this pack's Rules.
+	// libyara's external variables live on the compiled ruleset itself, not
+	// per-scan, so two goroutines scanning this pack concurrently with
+	// different file metadata would race without this -- it costs
+	// cross-file parallelism within one pack, not correctness.
+	scanMu sync.Mutex
+}
+
+// externalVariableDefaults declares the external variables community YARA
+// rulesets commonly reference (filename, filepath, extension, filetype) so
+// compiling against such a ruleset doesn't fail with "undefined identifier".
+// Real values are set per-scan via setScanVariables.
+var externalVariableDefaults = []struct {
+	name string
+	zero string
+}{
+	{"filename", ""},
+	{"filepath", ""},
+	{"extension", ""},
+	{"filetype", ""},
+}
+
+// packs holds every currently-compiled pack, keyed by name, behind
+// rulesMutex so a scan never observes a half-reloaded map.
+var packs = map[string]*Pack{}
+
+func packNameOrDefault(name string) string {
+	if name == "" {
+		return defaultPackName
+	}
+	return name
+}
+
+func getPack(name string) (*Pack, bool) {
+	rulesMutex.RLock()
+	defer rulesMutex.RUnlock()
+	pack, ok := packs[packNameOrDefault(name)]
+	return pack, ok
+}
+
+func loadedPackNames() []string {
+	rulesMutex.RLock()
+	defer rulesMutex.RUnlock()
+	names := make([]string, 0, len(packs))
+	for name := range packs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func rulesCacheDir() string {
+	dir := os.Getenv("YARA_RULES_CACHE_DIR")
+	if dir == "" {
+		dir = "/tmp/yara-rule-cache"
+	}
+	return dir
+}
+
+// loadRuleConfig reads YARA_RULES_CONFIG (YAML or JSON, chosen by
+// extension) if set. Otherwise it falls back to a single "default" pack
+// backed by YARA_RULES_DIR, which is the pre-rule-pack behavior, so a
+// deployment that only ever set YARA_RULES_DIR keeps working unchanged.
+func loadRuleConfig() ([]PackConfig, error) {
+	configPath := os.Getenv("YARA_RULES_CONFIG")
+	if configPath == "" {
+		dir := os.Getenv("YARA_RULES_DIR")
+		if dir == "" {
+			dir = "/rules"
+		}
+		return []PackConfig{{Name: defaultPackName, Sources: []PackSource{dir}}}, nil
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read rules config: %w", err)
+	}
+
+	var cf rulesConfigFile
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".json":
+		err = json.Unmarshal(data, &cf)
+	default:
+		err = yaml.Unmarshal(data, &cf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse rules config: %w", err)
+	}
+	if len(cf.Packs) == 0 {
+		return nil, fmt.Errorf("rules config %s defines no packs", configPath)
+	}
+	return cf.Packs, nil
+}
+
+// loadAllPacks compiles every pack from the rule-source config and replaces
+// the whole pack set in one swap, so a reload can't be observed half-applied
+// by a scan running concurrently.
+func loadAllPacks() error {
+	configs, err := loadRuleConfig()
+	if err != nil {
+		return err
+	}
+
+	compiled := make(map[string]*Pack, len(configs))
+	var firstErr error
+	for _, cfg := range configs {
+		pack, err := compilePack(cfg)
+		if err != nil {
+			log.Printf("Warning: failed to compile pack %q: %v", cfg.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		compiled[cfg.Name] = pack
+		log.Printf("Loaded pack %q: %d rule files", cfg.Name, len(pack.RuleSource))
+	}
+
+	if len(compiled) == 0 {
+		return fmt.Errorf("no packs could be compiled: %w", firstErr)
+	}
+
+	rulesMutex.Lock()
+	packs = compiled
+	rulesMutex.Unlock()
+	return nil
+}
+
+// reloadPack recompiles a single named pack from the config and swaps it
+// into the pack map, leaving every other pack's compiled rules untouched.
+func reloadPack(name string) error {
+	configs, err := loadRuleConfig()
+	if err != nil {
+		return err
+	}
+	for _, cfg := range configs {
+		if cfg.Name != name {
+			continue
+		}
+		pack, err := compilePack(cfg)
+		if err != nil {
+			return err
+		}
+		rulesMutex.Lock()
+		packs[name] = pack
+		rulesMutex.Unlock()
+		log.Printf("Reloaded pack %q: %d rule files", name, len(pack.RuleSource))
+		return nil
+	}
+	return fmt.Errorf("pack %q not found in rules config", name)
+}
+
+func isYaraFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yar" || ext == ".yara"
+}
+
+// compilePack resolves every source in cfg (fetching URLs and cloning git
+// package references into the cache dir as needed), compiles every .yar/
+// .yara file it finds into one namespaced *yara.Rules, and records each
+// file's raw source for later retrieval.
+func compilePack(cfg PackConfig) (*Pack, error) {
+	cacheDir := rulesCacheDir()
+	compiler, err := yara.NewCompiler()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create YARA compiler: %w", err)
+	}
+
+	// External variables must be declared before any rule file that
+	// references them is compiled, so rulesets built around
+	// filename/filepath/extension/filetype conditions (a common pattern in
+	// community rule packs) compile instead of failing on an undefined
+	// identifier.
+	for _, v := range externalVariableDefaults {
+		if err := compiler.DefineVariable(v.name, v.zero); err != nil {
+			return nil, fmt.Errorf("define external variable %q: %w", v.name, err)
+		}
+	}
+
+	ruleSource := make(map[string]string)
+
+	for _, source := range cfg.Sources {
+		resolved, err := resolveSource(source, cacheDir)
+		if err != nil {
+			log.Printf("Warning: failed to resolve rule source %q for pack %q: %v", source, cfg.Name, err)
+			continue
+		}
+
+		info, err := os.Stat(resolved)
+		if err != nil {
+			log.Printf("Warning: rule source %q for pack %q not found: %v", source, cfg.Name, err)
+			continue
+		}
+
+		var ruleFiles []string
+		if info.IsDir() {
+			walkErr := filepath.Walk(resolved, func(path string, fi os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !fi.IsDir() && isYaraFile(path) {
+					ruleFiles = append(ruleFiles, path)
+				}
+				return nil
+			})
+			if walkErr != nil {
+				log.Printf("Warning: failed to walk rule source %q: %v", resolved, walkErr)
+				continue
+			}
+		} else if isYaraFile(resolved) {
+			ruleFiles = append(ruleFiles, resolved)
+		}
+
+		for _, ruleFile := range ruleFiles {
+			f, err := os.Open(ruleFile)
+			if err != nil {
+				log.Printf("Warning: Failed to open rule file %s: %v", ruleFile, err)
+				continue
+			}
+
+			namespace := filepath.Base(ruleFile)
+			namespace = strings.TrimSuffix(namespace, filepath.Ext(namespace))
+			err = compiler.AddFile(f, namespace)
+			f.Close() // Close immediately after use, not deferred
+			if err != nil {
+				log.Printf("Warning: Failed to compile rule file %s: %v", ruleFile, err)
+				continue
+			}
+
+			if data, err := os.ReadFile(ruleFile); err == nil {
+				ruleSource[namespace] = string(data)
+			}
+		}
+	}
+
+	if len(ruleSource) == 0 {
+		return nil, fmt.Errorf("no YARA rule files found for pack %q", cfg.Name)
+	}
+
+	rules, err := compiler.GetRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get compiled rules for pack %q: %w", cfg.Name, err)
+	}
+
+	return &Pack{
+		Name:       cfg.Name,
+		Rules:      rules,
+		Sources:    cfg.Sources,
+		RuleSource: ruleSource,
+	}, nil
+}
+
+// resolveSource turns one PackSource into a local path compilePack can walk.
+// Local directories and files are used as-is; HTTP(S) URLs and
+// "pkg:github/..." references are fetched into cacheDir first.
+func resolveSource(source PackSource, cacheDir string) (string, error) {
+	switch {
+	case strings.HasPrefix(source, "pkg:github/"):
+		return fetchGitPackageSource(source, cacheDir)
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		return fetchURLSource(source, cacheDir)
+	default:
+		return source, nil
+	}
+}
+
+// fetchGitPackageSource clones (or updates a cached clone of) the repo named
+// by a package-url-style github reference --
+// "pkg:github/<owner>/<repo>[@<ref>]#<subdir>" -- and returns the path to
+// the requested subdirectory, following the same shape signature-base-style
+// rule aggregators use to pin a rule source to a repo and ref.
+func fetchGitPackageSource(purl PackSource, cacheDir string) (string, error) {
+	rest := strings.TrimPrefix(purl, "pkg:github/")
+
+	var subdir string
+	if idx := strings.Index(rest, "#"); idx >= 0 {
+		subdir = rest[idx+1:]
+		rest = rest[:idx]
+	}
+
+	var ref string
+	if idx := strings.Index(rest, "@"); idx >= 0 {
+		ref = rest[idx+1:]
+		rest = rest[:idx]
+	}
+
+	parts := strings.SplitN(rest, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid github package url %q: expected pkg:github/<owner>/<repo>", purl)
+	}
+	owner, repo := parts[0], parts[1]
+
+	dest := filepath.Join(cacheDir, "github", owner, repo)
+	if _, err := os.Stat(filepath.Join(dest, ".git")); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return "", fmt.Errorf("create git cache dir: %w", err)
+		}
+		args := []string{"clone", "--depth", "1"}
+		if ref != "" {
+			args = append(args, "--branch", ref)
+		}
+		args = append(args, fmt.Sprintf("https://github.com/%s/%s.git", owner, repo), dest)
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("git clone %s/%s: %w: %s", owner, repo, err, strings.TrimSpace(string(out)))
+		}
+	} else if out, err := exec.Command("git", "-C", dest, "pull", "--ff-only").CombinedOutput(); err != nil {
+		// A stale cached clone shouldn't block compiling the pack against
+		// whatever was already fetched -- log and keep using it.
+		log.Printf("Warning: failed to update cached clone %s/%s: %v: %s", owner, repo, err, strings.TrimSpace(string(out)))
+	}
+
+	if subdir == "" {
+		return dest, nil
+	}
+	return filepath.Join(dest, subdir), nil
+}
+
+// fetchURLSource downloads a single rule file into cacheDir, keyed by host
+// so repeated pack reloads re-fetch the same URL to the same path.
+func fetchURLSource(rawURL PackSource, cacheDir string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid rule URL %q: %w", rawURL, err)
+	}
+	name := filepath.Base(u.Path)
+	if name == "" || name == "." || name == "/" {
+		name = "rules.yar"
+	}
+
+	destDir := filepath.Join(cacheDir, "urls", url.PathEscape(u.Host))
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return "", fmt.Errorf("create url cache dir: %w", err)
+	}
+	dest := filepath.Join(destDir, name)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetch %s: unexpected status %s", rawURL, resp.Status)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return "", fmt.Errorf("write %s: %w", dest, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return "", fmt.Errorf("save %s: %w", dest, err)
+	}
+	return dest, nil
+}
+
+// PackInfo is one entry in GET /packs.
+type PackInfo struct {
+	Name      string   `json:"name"`
+	Sources   []string `json:"sources"`
+	RuleCount int      `json:"rule_count"`
+}
+
+func packsHandler(c *gin.Context) {
+	rulesMutex.RLock()
+	infos := make([]PackInfo, 0, len(packs))
+	for _, pack := range packs {
+		infos = append(infos, PackInfo{
+			Name:      pack.Name,
+			Sources:   pack.Sources,
+			RuleCount: len(pack.RuleSource),
+		})
+	}
+	rulesMutex.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	c.JSON(http.StatusOK, gin.H{"packs": infos})
+}
+
+// rulesPackHandler serves the concatenated raw YARA source of every rule
+// file in a pack, so a downstream tool can pull the whole pack aggregation
+// in one request.
+func rulesPackHandler(c *gin.Context) {
+	pack, ok := getPack(c.Param("pack"))
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("pack %q not found", c.Param("pack"))})
+		return
+	}
+
+	names := make([]string, 0, len(pack.RuleSource))
+	for name := range pack.RuleSource {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(pack.RuleSource[name])
+		b.WriteString("\n")
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(b.String()))
+}
+
+// ruleHandler serves one rule file's raw YARA source out of a pack, mirroring
+// how a rule server exposes individual rules alongside pack aggregations.
+func ruleHandler(c *gin.Context) {
+	packName := c.Param("pack")
+	ruleID := c.Param("rule_id")
+
+	pack, ok := getPack(packName)
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("pack %q not found", packName)})
+		return
+	}
+
+	source, ok := pack.RuleSource[ruleID]
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("rule %q not found in pack %q", ruleID, packName)})
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(source))
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"compress/gzip"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -12,6 +14,8 @@ import (
 	"strings"
 
 	"github.com/gabriel-vasile/mimetype"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
 // FileType represents the detected file type
@@ -108,16 +112,10 @@ func detectFileTypeByExtension(filename string) FileType {
 	return Binary
 }
 
-// readTextFile reads a text file line by line and calculates entropy for each line
-func readTextFile(filename string) ([]float64, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
+// readTextFile reads a text stream line by line and calculates entropy for each line
+func readTextFile(r io.Reader) ([]float64, error) {
 	var entropies []float64
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Bytes()
 		if len(line) == 0 {
@@ -129,18 +127,12 @@ func readTextFile(filename string) ([]float64, error) {
 	return entropies, scanner.Err()
 }
 
-// readBinaryFile reads a binary file in 1KB blocks and calculates entropy for each block
-func readBinaryFile(filename string) ([]float64, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
+// readBinaryFile reads a binary stream in 1KB blocks and calculates entropy for each block
+func readBinaryFile(r io.Reader) ([]float64, error) {
 	var entropies []float64
 	buffer := make([]byte, 1024) // 1KB block
 	for {
-		n, err := file.Read(buffer)
+		n, err := r.Read(buffer)
 		if err != nil && err != io.EOF {
 			return nil, err
 		}
@@ -153,6 +145,382 @@ func readBinaryFile(filename string) ([]float64, error) {
 	return entropies, nil
 }
 
+// Chunk boundaries for readBinaryFileCDC are clamped to this range so a long
+// run of the same byte (e.g. zero-padding) can never produce a
+// pathologically tiny or huge chunk.
+const (
+	cdcMinSize = 2 * 1024
+	cdcAvgSize = 8 * 1024
+	cdcMaxSize = 64 * 1024
+)
+
+// gearTable is a fixed table of 256 pseudo-random uint64s used to compute the
+// FastCDC "gear hash". The values themselves don't matter for correctness,
+// only that they're fixed so chunk boundaries are reproducible across runs.
+var gearTable = generateGearTable()
+
+func generateGearTable() [256]uint64 {
+	// A small xorshift64 PRNG seeded with a fixed constant, so the table is
+	// deterministic without needing math/rand or an external dependency.
+	var table [256]uint64
+	state := uint64(0x9E3779B97F4A7C15)
+	for i := range table {
+		state ^= state << 13
+		state ^= state >> 7
+		state ^= state << 17
+		table[i] = state
+	}
+	return table
+}
+
+// maskS and maskL are the "small" and "large" cut masks from the FastCDC
+// paper: maskS is checked before the average size is reached (harder to
+// satisfy, biasing toward larger chunks), maskL after (easier to satisfy,
+// biasing toward a cut once we're past the average).
+const (
+	cdcMaskS = 0x0000d93003530000
+	cdcMaskL = 0x0000d90003530000
+)
+
+// CDCChunk is one content-defined chunk: its byte range within the file and
+// its Shannon entropy, computed the same way as the fixed-block path.
+type CDCChunk struct {
+	Start   int64
+	End     int64
+	Entropy float64
+}
+
+// readBinaryFileCDC walks filename with a FastCDC-style rolling hash instead
+// of fixed-size blocks, so entropy estimates stay aligned with actual
+// content boundaries (headers, tar padding, pgBackRest bundle framing)
+// rather than arbitrary offsets. It returns both the flat list of entropies
+// (for the existing histogram/percentile pipeline) and the chunk boundaries.
+func readBinaryFileCDC(filename string) ([]float64, []CDCChunk, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entropies []float64
+	var chunks []CDCChunk
+	var offset int64
+	for offset < int64(len(data)) {
+		cut := findCDCCut(data[offset:])
+		chunk := data[offset : offset+int64(cut)]
+		ent := entropy(chunk)
+		entropies = append(entropies, ent)
+		chunks = append(chunks, CDCChunk{
+			Start:   offset,
+			End:     offset + int64(cut),
+			Entropy: ent,
+		})
+		offset += int64(cut)
+	}
+
+	return entropies, chunks, nil
+}
+
+// findCDCCut returns the length of the next chunk to cut from the start of
+// data. The Gear-hash accumulates one byte at a time into a rolling 64-bit
+// value (effectively windowed, since shifting left by one each step retires
+// bits older than 48-64 positions back); before the average size we cut only
+// on the stricter maskS, after it on the looser maskL. If no cut point is
+// found before cdcMaxSize (or the end of data), the chunk is cut there.
+func findCDCCut(data []byte) int {
+	n := len(data)
+	if n <= cdcMinSize {
+		return n
+	}
+	limit := cdcMaxSize
+	if limit > n {
+		limit = n
+	}
+
+	var hash uint64
+	for i := cdcMinSize; i < limit; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if i < cdcAvgSize {
+			if hash&cdcMaskS == 0 {
+				return i + 1
+			}
+		} else {
+			if hash&cdcMaskL == 0 {
+				return i + 1
+			}
+		}
+	}
+	return limit
+}
+
+// Magic bytes for the transparent compression formats common in backup
+// tooling output (pg_dump custom format wraps gzip, pgBackRest emits zstd
+// or gzip, some archival pipelines use xz).
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}
+)
+
+// detectCompression sniffs buf's first few bytes for a known compression
+// wrapper, same set of magic numbers the pipeline's compressed-stream layer
+// recognizes.
+func detectCompression(buf []byte) string {
+	hasPrefix := func(magic []byte) bool {
+		if len(buf) < len(magic) {
+			return false
+		}
+		for i, b := range magic {
+			if buf[i] != b {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case hasPrefix(xzMagic):
+		return "xz"
+	case hasPrefix(zstdMagic):
+		return "zstd"
+	case hasPrefix(gzipMagic):
+		return "gzip"
+	default:
+		return ""
+	}
+}
+
+// decompressStream wraps r in the decompressor matching format, supporting
+// concatenated gzip members and zstd frames transparently since
+// pg_dump/pgBackRest often emit them back to back.
+func decompressStream(format string, r io.Reader) (io.Reader, error) {
+	switch format {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case "xz":
+		return xz.NewReader(bufio.NewReader(r))
+	default:
+		return r, nil
+	}
+}
+
+// sampleEntropy reads up to 64KiB from r and returns its Shannon entropy.
+func sampleEntropy(r io.Reader) float64 {
+	buf := make([]byte, 65536)
+	n, _ := io.ReadFull(r, buf)
+	if n == 0 {
+		return 0
+	}
+	return entropy(buf[:n])
+}
+
+// Sliding-window entropy localization parameters (same defaults as the
+// pipeline's copy): a single whole-file sample collapses any local
+// high-entropy region into the average, so --localize walks the stream in
+// overlapping windows instead.
+const (
+	entropyWindow         = 4096
+	entropyStride         = 1024
+	entropyRegionMinRun   = 3    // consecutive windows above threshold to call it a region
+	entropyRegionMergeGap = 2048 // merge regions separated by less than this many bytes
+)
+
+// EntropyPoint is one (offset, entropy) sample from slidingWindowEntropy.
+type EntropyPoint struct {
+	Offset  int64
+	Entropy float64
+}
+
+// EntropyRegion is a contiguous run of high-entropy windows, merged with
+// nearby runs.
+type EntropyRegion struct {
+	Start       int64
+	End         int64
+	MeanEntropy float64
+}
+
+// entropyFromFreq computes Shannon entropy from a running 256-bucket byte
+// frequency histogram in O(256), independent of the window size it was
+// accumulated over.
+func entropyFromFreq(freq [256]int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	var ent float64
+	ln := float64(total)
+	for _, c := range freq {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / ln
+		ent -= p * math.Log2(p)
+	}
+	return ent
+}
+
+// slidingWindowEntropy walks r in windows of `window` bytes that advance by
+// `stride` bytes, maintaining an incremental byte-frequency histogram:
+// each step decrements counts for the stride bytes leaving the window and
+// increments counts for the stride bytes entering it, then recomputes
+// entropy from the histogram rather than rescanning the whole window.
+func slidingWindowEntropy(r io.Reader, window, stride int) ([]EntropyPoint, error) {
+	if window <= 0 || stride <= 0 || stride > window {
+		return nil, fmt.Errorf("invalid window/stride: %d/%d", window, stride)
+	}
+
+	buf := make([]byte, window)
+	filled, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if filled == 0 {
+			return nil, nil
+		}
+		var freq [256]int
+		for _, b := range buf[:filled] {
+			freq[b]++
+		}
+		return []EntropyPoint{{Offset: 0, Entropy: entropyFromFreq(freq, filled)}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var freq [256]int
+	for _, b := range buf {
+		freq[b]++
+	}
+
+	points := []EntropyPoint{{Offset: 0, Entropy: entropyFromFreq(freq, window)}}
+
+	step := make([]byte, stride)
+	cursor := 0
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, step)
+		for i := 0; i < n; i++ {
+			leaving := buf[(cursor+i)%window]
+			freq[leaving]--
+			entering := step[i]
+			freq[entering]++
+			buf[(cursor+i)%window] = entering
+		}
+		if n > 0 {
+			cursor = (cursor + n) % window
+			offset += int64(n)
+			points = append(points, EntropyPoint{Offset: offset, Entropy: entropyFromFreq(freq, window)})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return points, nil
+}
+
+// detectEntropyRegions finds contiguous runs of at least minRun windows
+// whose entropy exceeds threshold, then merges runs separated by less than
+// mergeGap bytes into a single region.
+func detectEntropyRegions(points []EntropyPoint, window int, threshold float64, minRun int, mergeGap int64) []EntropyRegion {
+	var regions []EntropyRegion
+
+	runStart := -1
+	var runSum float64
+	var runCount int
+	flush := func(endIdx int) {
+		if runStart == -1 {
+			return
+		}
+		if runCount >= minRun {
+			regions = append(regions, EntropyRegion{
+				Start:       points[runStart].Offset,
+				End:         points[endIdx].Offset + int64(window),
+				MeanEntropy: runSum / float64(runCount),
+			})
+		}
+		runStart = -1
+		runSum = 0
+		runCount = 0
+	}
+
+	for i, p := range points {
+		if p.Entropy > threshold {
+			if runStart == -1 {
+				runStart = i
+			}
+			runSum += p.Entropy
+			runCount++
+		} else {
+			flush(i - 1)
+		}
+	}
+	flush(len(points) - 1)
+
+	if len(regions) == 0 {
+		return regions
+	}
+	merged := []EntropyRegion{regions[0]}
+	for _, r := range regions[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start-last.End < mergeGap {
+			last.End = r.End
+			last.MeanEntropy = (last.MeanEntropy + r.MeanEntropy) / 2
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+// printStripChart renders an ASCII strip chart of entropy over file offset:
+// points are bucketed into `width` columns spanning the file, each non-empty
+// column printed as one line with a horizontal bar of its mean entropy.
+func printStripChart(points []EntropyPoint, width int) {
+	if len(points) == 0 {
+		return
+	}
+	lastOffset := points[len(points)-1].Offset
+	if lastOffset == 0 {
+		lastOffset = 1
+	}
+
+	sums := make([]float64, width)
+	counts := make([]int, width)
+	for _, p := range points {
+		col := int(p.Offset * int64(width) / lastOffset)
+		if col >= width {
+			col = width - 1
+		}
+		sums[col] += p.Entropy
+		counts[col]++
+	}
+
+	fmt.Println("\nEntropy strip chart (offset -> mean entropy, 0-8 bits/byte):")
+	for col := 0; col < width; col++ {
+		if counts[col] == 0 {
+			continue
+		}
+		mean := sums[col] / float64(counts[col])
+		barLength := int(mean / 8 * 50)
+		if barLength > 50 {
+			barLength = 50
+		}
+		offset := lastOffset * int64(col) / int64(width)
+		fmt.Printf("%12d |%-50s| %.2f\n", offset, strings.Repeat("#", barLength), mean)
+	}
+}
+
 // printHistogram displays an ASCII histogram of the entropy distribution
 func printHistogram(data []float64, binSize float64) {
 	if len(data) == 0 {
@@ -231,13 +599,19 @@ func suggestThreshold(data []float64, fileType FileType) float64 {
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: go run entropy-analyzer.go <file1> [file2] [file3]...")
-		fmt.Println("   or: ./entropy-analyzer <file1> [file2] [file3]...")
+	cdc := flag.Bool("cdc", false, "use FastCDC content-defined chunking instead of fixed 1KiB blocks for binary files")
+	localize := flag.Bool("localize", false, "sliding-window entropy localization: print top high-entropy regions and a strip chart instead of the usual histogram")
+	flag.Usage = func() {
+		fmt.Println("Usage: entropy-analyzer [--cdc] [--localize] <file1> [file2] [file3]...")
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	for _, filename := range os.Args[1:] {
+	for _, filename := range flag.Args() {
 		fmt.Printf("\n=== Analyzing file: %s ===\n", filename)
 
 		// Detect and display MIME type
@@ -250,16 +624,66 @@ func main() {
 
 		fileType := detectFileType(filename)
 		var entropies []float64
+		var chunks []CDCChunk
 		var err error
 
+		raw, openErr := os.Open(filename)
+		if openErr != nil {
+			log.Printf("Error opening %s: %v\n", filename, openErr)
+			continue
+		}
+		peek := make([]byte, 6)
+		n, _ := io.ReadFull(raw, peek)
+		peek = peek[:n]
+		compression := detectCompression(peek)
+		if compression != "" {
+			rawSample := sampleEntropy(raw)
+			fmt.Printf("Compression detected: %s (raw entropy of compressed bytes: %.3f bits/byte)\n", compression, rawSample)
+		}
+		if _, seekErr := raw.Seek(0, io.SeekStart); seekErr != nil {
+			raw.Close()
+			log.Printf("Error rewinding %s: %v\n", filename, seekErr)
+			continue
+		}
+
+		var content io.Reader = raw
+		if compression != "" {
+			content, err = decompressStream(compression, raw)
+			if err != nil {
+				raw.Close()
+				log.Printf("Error opening %s stream for %s: %v\n", compression, filename, err)
+				continue
+			}
+		}
+
+		if *localize {
+			points, lwErr := slidingWindowEntropy(content, entropyWindow, entropyStride)
+			raw.Close()
+			if lwErr != nil {
+				log.Printf("Error localizing entropy in %s: %v\n", filename, lwErr)
+				continue
+			}
+			printLocalizedRegions(points)
+			continue
+		}
+
 		switch fileType {
 		case Text:
 			fmt.Println("Detected type: Text (analyzing line by line)")
-			entropies, err = readTextFile(filename)
+			entropies, err = readTextFile(content)
 		case Binary:
-			fmt.Println("Detected type: Binary (analyzing in 1KB blocks)")
-			entropies, err = readBinaryFile(filename)
+			if *cdc && compression != "" {
+				fmt.Println("--cdc ignored for compressed input: the decompressed stream is analyzed in fixed 1KB blocks instead")
+			}
+			if *cdc && compression == "" {
+				fmt.Println("Detected type: Binary (analyzing with FastCDC content-defined chunks)")
+				entropies, chunks, err = readBinaryFileCDC(filename)
+			} else {
+				fmt.Println("Detected type: Binary (analyzing in 1KB blocks)")
+				entropies, err = readBinaryFile(content)
+			}
 		}
+		raw.Close()
 
 		if err != nil {
 			log.Printf("Error reading %s: %v\n", filename, err)
@@ -306,9 +730,49 @@ func main() {
 			percentage := float64(aboveThreshold) / float64(len(entropies)) * 100
 			fmt.Printf("\n  Samples above pipeline threshold (6.5): %d (%.1f%%)\n", aboveThreshold, percentage)
 		}
+
+		if len(chunks) > 0 {
+			fmt.Printf("\nContent-defined chunks (%d):\n", len(chunks))
+			for _, ch := range chunks {
+				fmt.Printf("  [%10d-%10d] (%6d bytes) entropy=%.3f\n", ch.Start, ch.End, ch.End-ch.Start, ch.Entropy)
+			}
+		}
 	}
 }
 
+// printLocalizedRegionsTopK bounds how many high-entropy regions --localize
+// prints; a file riddled with them would otherwise flood the terminal.
+const printLocalizedRegionsTopK = 10
+
+// printLocalizedRegions prints the top high-entropy regions found by
+// slidingWindowEntropy/detectEntropyRegions plus an ASCII strip chart of
+// entropy over the whole file, so an analyst can see roughly where a region
+// sits before `dd`-ing the exact byte range out for inspection.
+func printLocalizedRegions(points []EntropyPoint) {
+	if len(points) == 0 {
+		fmt.Println("No data to analyze.")
+		return
+	}
+
+	regions := detectEntropyRegions(points, entropyWindow, 6.5, entropyRegionMinRun, entropyRegionMergeGap)
+	sort.Slice(regions, func(i, j int) bool { return regions[i].MeanEntropy > regions[j].MeanEntropy })
+
+	fmt.Printf("\nHigh-entropy regions (%d found, threshold 6.5, window=%d, stride=%d):\n", len(regions), entropyWindow, entropyStride)
+	if len(regions) == 0 {
+		fmt.Println("  none")
+	}
+	top := regions
+	if len(top) > printLocalizedRegionsTopK {
+		top = top[:printLocalizedRegionsTopK]
+		fmt.Printf("  (showing top %d by mean entropy, %d omitted)\n", printLocalizedRegionsTopK, len(regions)-printLocalizedRegionsTopK)
+	}
+	for _, r := range top {
+		fmt.Printf("  [%12d-%12d] (%10d bytes) mean entropy=%.3f\n", r.Start, r.End, r.End-r.Start, r.MeanEntropy)
+	}
+
+	printStripChart(points, 80)
+}
+
 func average(data []float64) float64 {
 	sum := 0.0
 	for _, v := range data {